@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+)
+
+// acks tracks, for every file, which events have been handed to the spoolers
+// and which of those have been acknowledged by a logstash server, so that the
+// registrar only ever records positions that everything before has been
+// acknowledged up to.  Pages can be acknowledged out of order, e.g. when there
+// is more than one publisher, and recording the end of a later page before an
+// earlier one has been acknowledged would lose the earlier one if we crashed
+// in between.
+var acks = &ackTracker{files: make(map[fileId]*fileAcks)}
+
+type ackTracker struct {
+	sync.Mutex
+	files  map[fileId]*fileAcks
+	epochs int // the last epoch handed out.  See fileAcks.epoch.
+}
+
+type fileAcks struct {
+	pending map[int64]int // offsets of events sent but not yet acknowledged
+	acked   int64         // end of the furthest acknowledged event
+	epoch   int           // changes whenever the file is rewound or reset
+}
+
+// only events read from a file, and not since rotated away, have a position
+// worth recording.
+func trackable(e *FileEvent) bool {
+	return e.fileinfo != nil && !e.Rotated && e.Source != "-" && e.Source != ""
+}
+
+func (t *ackTracker) file(id fileId) *fileAcks {
+	f, ok := t.files[id]
+	if !ok {
+		f = &fileAcks{pending: make(map[int64]int), epoch: t.newEpoch()}
+		t.files[id] = f
+	}
+	return f
+}
+
+// epochs are never reused, so that events sent before a file's entry was
+// reset, or dropped and made again, are never taken for ones sent after.
+func (t *ackTracker) newEpoch() int {
+	t.epochs++
+	return t.epochs
+}
+
+// records that e has been handed off for sending.
+func (t *ackTracker) sent(e *FileEvent) {
+	if !trackable(e) {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	f := t.file(filestring(e.fileinfo))
+	e.epoch = f.epoch
+	f.pending[e.Offset]++
+}
+
+// records that the file with the given id has been rewound, so that positions
+// recorded from here on start again from the beginning.
+//
+// Events read before a file was truncated may still be on their way.  They're
+// shipped as usual, as they're all that's left of what was truncated, but
+// their offsets are in the old contents, so acknowledging them doesn't move
+// the recorded position, and nothing waits for them before recording
+// positions in the new contents.  A restart before they're acknowledged
+// doesn't read them again, as they're gone from the file.
+func (t *ackTracker) rewound(id fileId) {
+	t.reset(id, 0)
+}
+
+// records that the file with the given id is now being read from offset.
+// Nothing sent from it should be waiting to be acknowledged.
+func (t *ackTracker) reset(id fileId, offset int64) {
+	t.Lock()
+	defer t.Unlock()
+	f := t.file(id)
+	f.acked = offset
+	f.pending = make(map[int64]int)
+	f.epoch = t.newEpoch()
+}
+
+// records that all the events in page have been acknowledged, and returns,
+// for each file in page, the position it's safe to resume that file from.
+func (t *ackTracker) acknowledge(page eventPage) map[fileId]int64 {
+	t.Lock()
+	defer t.Unlock()
+
+	touched := make(map[fileId]*fileAcks)
+	for _, e := range page {
+		if !trackable(e) {
+			continue
+		}
+		id := filestring(e.fileinfo)
+		f, ok := t.files[id]
+		if !ok {
+			// dropped by forget.  See forget.
+			continue
+		}
+		touched[id] = f
+		if e.epoch != f.epoch {
+			// read before the file was rewound.  See rewound.
+			continue
+		}
+		if n := f.pending[e.Offset]; n > 1 {
+			f.pending[e.Offset] = n - 1
+		} else {
+			delete(f.pending, e.Offset)
+		}
+		if end := e.Offset + e.length; end > f.acked {
+			f.acked = end
+		}
+	}
+
+	safe := make(map[fileId]int64, len(touched))
+	for id, f := range touched {
+		offset := f.acked
+		for pending := range f.pending {
+			if pending < offset {
+				offset = pending
+			}
+		}
+		safe[id] = offset
+	}
+	return safe
+}
+
+// reports whether any of the events sent from the file with the given id are
+// yet to be acknowledged.
+func (t *ackTracker) unacknowledged(id fileId) bool {
+	t.Lock()
+	defer t.Unlock()
+	f, ok := t.files[id]
+	return ok && len(f.pending) > 0
+}
+
+// forgets about a file once its harvester is done with it.  A file with events
+// still to be acknowledged is kept, so that its position doesn't skip them,
+// unless it's been deleted: then it won't be read again, and its id may be
+// reused by a new file, which mustn't wait for them.  Their acknowledgements
+// are ignored when they come.
+func (t *ackTracker) forget(id fileId, deleted bool) {
+	t.Lock()
+	defer t.Unlock()
+	if f, ok := t.files[id]; ok && (deleted || len(f.pending) == 0) {
+		delete(t.files, id)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the info of a file that exists until the test ends, so that no other file
+// gets its inode meanwhile.
+func tempFileInfo(t *testing.T) os.FileInfo {
+	f, err := ioutil.TempFile("", "acks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	defer f.Close()
+	info, err := statOpen(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// gives the test an ackTracker of its own, so that it doesn't see what other
+// tests left unacknowledged.  The returned func puts the shared one back.
+func testAcks() func() {
+	saved := acks
+	acks = &ackTracker{files: make(map[fileId]*fileAcks)}
+	return func() { acks = saved }
+}
+
+// events are read and handed off, but only a later page is acknowledged
+// before we crash.  The recorded position must not skip the unacknowledged
+// events.
+func TestProgressOnlyRecordsAcknowledged(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	var events []*FileEvent
+	for i := int64(0); i < 4; i++ {
+		e := &FileEvent{Source: "/var/log/app.log", Offset: i * 10, length: 10, fileinfo: info}
+		acks.sent(e)
+		events = append(events, e)
+	}
+
+	later := eventPage{events[2], events[3]}
+	prog := later.progress()
+	if offset := prog["/var/log/app.log"].Offset; offset != 0 {
+		t.Fatalf("recorded offset %d with events before it unacknowledged", offset)
+	}
+
+	// a crash here, and a restart from the recorded position, re-reads
+	// everything.  Once the earlier page is acknowledged, the position moves
+	// past everything.
+	earlier := eventPage{events[0], events[1]}
+	prog = earlier.progress()
+	if offset := prog["/var/log/app.log"].Offset; offset != 40 {
+		t.Fatalf("expected offset 40 once everything was acknowledged, got %d", offset)
+	}
+}
+
+func TestProgressPartialPage(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	first := &FileEvent{Source: "/var/log/app.log", Offset: 0, length: 6, fileinfo: info}
+	second := &FileEvent{Source: "/var/log/app.log", Offset: 6, length: 6, fileinfo: info}
+	acks.sent(first)
+	acks.sent(second)
+
+	page := eventPage{first}
+	if offset := page.progress()["/var/log/app.log"].Offset; offset != 6 {
+		t.Fatalf("expected offset 6, got %d", offset)
+	}
+}
+
+// an event routed to an error_dest is sent to two network groups.  Its
+// position mustn't be recorded until both have acknowledged it.
+func TestProgressRoutedCopies(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	e := &FileEvent{Source: "/var/log/app.log", Offset: 0, length: 6, fileinfo: info}
+	acks.sent(e)
+	acks.sent(e)
+
+	page := eventPage{e}
+	if offset := page.progress()["/var/log/app.log"].Offset; offset != 0 {
+		t.Fatalf("recorded offset %d with one copy unacknowledged", offset)
+	}
+	if offset := page.progress()["/var/log/app.log"].Offset; offset != 6 {
+		t.Fatalf("expected offset 6 once both copies were acknowledged, got %d", offset)
+	}
+}
+
+// a file is truncated while events read from its old contents are still
+// waiting to be acknowledged.  They mustn't move the position recorded in
+// the new contents, or hold it back.
+func TestProgressAcrossTruncation(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	id := filestring(info)
+	old := &FileEvent{Source: "/var/log/app.log", Offset: 100, length: 50, fileinfo: info}
+	acks.sent(old)
+
+	acks.rewound(id)
+	first := &FileEvent{Source: "/var/log/app.log", Offset: 0, length: 10, fileinfo: info}
+	acks.sent(first)
+	if !acks.unacknowledged(id) {
+		t.Fatal("expected the event read after the truncation to be pending")
+	}
+
+	page := eventPage{old}
+	if offset := page.progress()["/var/log/app.log"].Offset; offset != 0 {
+		t.Fatalf("expected offset 0 with the new contents unacknowledged, got %d", offset)
+	}
+	page = eventPage{first}
+	if offset := page.progress()["/var/log/app.log"].Offset; offset != 10 {
+		t.Fatalf("expected offset 10 in the new contents, got %d", offset)
+	}
+	if acks.unacknowledged(id) {
+		t.Fatal("expected nothing pending once the new contents were acknowledged")
+	}
+}
+
+// a deleted file's events that are never acknowledged mustn't hold back a new
+// file given the same inode, and acknowledgements of them that do come
+// mustn't record a position for it.
+func TestAcksForgetDeleted(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	id := filestring(info)
+	old := &FileEvent{Source: "/var/log/old.log", Offset: 0, length: 10, fileinfo: info}
+	acks.sent(old)
+
+	acks.forget(id, false)
+	if !acks.unacknowledged(id) {
+		t.Fatal("expected a file with events pending to be kept")
+	}
+	acks.forget(id, true)
+	if acks.unacknowledged(id) {
+		t.Fatal("expected a deleted file to be forgotten")
+	}
+
+	page := eventPage{old}
+	if prog := page.progress(); len(prog) != 0 {
+		t.Fatalf("expected no position recorded for the deleted file, got %v", prog)
+	}
+	e := &FileEvent{Source: "/var/log/new.log", Offset: 0, length: 6, fileinfo: info}
+	acks.sent(e)
+	page = eventPage{e}
+	if offset := page.progress()["/var/log/new.log"].Offset; offset != 6 {
+		t.Fatalf("expected offset 6 in the new file, got %d", offset)
+	}
+}
+
+// a harvester reading a file from the beginning doesn't wait for events left
+// unacknowledged by whatever last had its id.
+func TestHarvesterOpenResetsAcks(t *testing.T) {
+	defer testAcks()()
+	info := tempFileInfo(t)
+	acks.sent(&FileEvent{Source: "/var/log/old.log", Offset: 100, length: 10, fileinfo: info})
+
+	h := newHarvester(filepath.Join(os.TempDir(), info.Name()), &FileConfig{}, nil)
+	h.open(0, h_Rewind)
+	defer h.file.Close()
+	if acks.unacknowledged(filestring(info)) {
+		t.Fatal("expected the events pending for the id to be dropped")
+	}
+}
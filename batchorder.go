@@ -0,0 +1,29 @@
+package main
+
+import (
+	"sort"
+)
+
+// orders for the events within each page, chosen per network group with
+// "batch_order".  By default events are left in the order they reached the
+// spooler, which interleaves the files being read.
+const (
+	orderSource   = "source"    // grouped by file, each in the order read
+	orderReadTime = "read_time" // by when each event was read
+)
+
+// puts the events of page in the given order.  Sorting is stable, so the
+// events of each file, which a harvester sends in the order it reads them,
+// stay in that order even when the file has been rewound.
+func orderPage(page eventPage, order string) {
+	switch order {
+	case orderSource:
+		sort.SliceStable(page, func(i, j int) bool {
+			return page[i].Source < page[j].Source
+		})
+	case orderReadTime:
+		sort.SliceStable(page, func(i, j int) bool {
+			return page[i].readAt.Before(page[j].readAt)
+		})
+	}
+}
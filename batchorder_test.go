@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrderPage(t *testing.T) {
+	now := time.Now()
+	page := eventPage{
+		{Source: "b.log", Text: "b1", readAt: now.Add(2 * time.Second)},
+		{Source: "a.log", Text: "a1", readAt: now},
+		{Source: "b.log", Text: "b2", readAt: now.Add(time.Second)},
+		// a.log was truncated, and read again from the start.
+		{Source: "a.log", Text: "a2", readAt: now.Add(3 * time.Second)},
+	}
+	for order, expected := range map[string][]string{
+		"":            {"b1", "a1", "b2", "a2"},
+		orderSource:   {"a1", "a2", "b1", "b2"},
+		orderReadTime: {"a1", "b2", "b1", "a2"},
+	} {
+		p := append(eventPage(nil), page...)
+		orderPage(p, order)
+		var texts []string
+		for _, e := range p {
+			texts = append(texts, e.Text)
+		}
+		if !reflect.DeepEqual(texts, expected) {
+			t.Errorf("order %q: expected %v, got %v", order, expected, texts)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+)
+
+// Clock is how harvesters tell the time and wait, so that tests of timeouts
+// and backoffs can use a fake one rather than waiting for real.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// the clock new harvesters use.
+var defaultClock Clock = wallClock{}
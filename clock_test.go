@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when something sleeps on it, or waits on After, and
+// then moves straight to the end of the wait.
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	after := make(chan time.Time, 1)
+	after <- c.Now()
+	return after
+}
+
+// with a fake clock, a day idle at EOF passes at once.
+func TestHarvesterIdleTimeout(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	clock := newFakeClock()
+	h.clock = clock
+	start := clock.Now()
+
+	done := startReadlines(h, 24*time.Hour)
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("harvester didn't time out")
+	}
+	if idle := clock.Now().Sub(start); idle <= 24*time.Hour {
+		t.Errorf("expected to wait over 24h, waited %v", idle)
+	}
+}
+
+// creates a file after being slept on a few times, recording each sleep.
+type creatingClock struct {
+	*fakeClock
+	path   string
+	after  int
+	sleeps []time.Duration
+}
+
+func (c *creatingClock) Sleep(d time.Duration) {
+	c.fakeClock.Sleep(d)
+	c.sleeps = append(c.sleeps, d)
+	if len(c.sleeps) == c.after {
+		ioutil.WriteFile(c.path, []byte("one\n"), 0644)
+	}
+}
+
+func TestHarvesterOpenRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "harvester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "late.log")
+	c := &creatingClock{fakeClock: newFakeClock(), path: path, after: 5}
+	conf := &FileConfig{OpenRetry: &openRetrySpec{BackoffMs: 100, BackoffFactor: 2, MaxBackoffMs: 500}}
+	h := newHarvester(path, conf, make(chan *FileEvent))
+	h.clock = c
+	if f := h.open(0, 0); f == nil {
+		t.Fatal("expected the file to be opened")
+	}
+	defer h.file.Close()
+
+	expected := []time.Duration{100, 200, 400, 500, 500}
+	if len(c.sleeps) != len(expected) {
+		t.Fatalf("expected %d retries, got %v", len(expected), c.sleeps)
+	}
+	for i, d := range expected {
+		if c.sleeps[i] != d*time.Millisecond {
+			t.Fatalf("retry %d: expected to wait %v, got %v", i, d*time.Millisecond, c.sleeps[i])
+		}
+	}
+}
@@ -61,7 +61,7 @@ func defineReplayCmd(conf *Config) {
 				fmt.Fprintf(w, "unable to get event chan for file path")
 				return
 			}
-			h := &Harvester{Path: args[0], Fields: fields, out: c, join: nil}
+			h := newHarvester(args[0], &FileConfig{Fields: fields}, c)
 			fmt.Fprintln(w, "ok")
 			go h.Harvest(offset, h_Rewind)
 		},
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// codec determines how the text of each line is turned into event fields.
+//
+//   - plain: the default.  The line is shipped as is.
+//   - json: the line is parsed as a JSON object, and each of its keys becomes
+//     a field.  Values that aren't strings are shipped as their JSON encoding.
+//   - kv: the line is split on whitespace into key=value pairs, each of which
+//     becomes a field.  Values may be double quoted to include whitespace.
+//
+// If a line can't be decoded, it's shipped as plain text with a codec_error
+// field describing the failure.
+type codec string
+
+const (
+	codecPlain codec = "plain"
+	codecJSON  codec = "json"
+	codecKV    codec = "kv"
+)
+
+func (c *codec) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal codec: %v", err)
+	}
+	switch v := codec(s); v {
+	case "":
+		*c = codecPlain
+	case codecPlain, codecJSON, codecKV:
+		*c = v
+	default:
+		return fmt.Errorf("illegal codec: %q", s)
+	}
+	return nil
+}
+
+// decodes text into fields.  Fields are only added if the whole line decodes
+// successfully.
+func (c codec) decode(text string, fields map[string]string) error {
+	switch c {
+	case codecJSON:
+		return decodeJSON(text, fields)
+	case codecKV:
+		return decodeKV(text, fields)
+	}
+	return nil
+}
+
+// whether a field decoded by the codec should be shipped, according to the
+// include_fields and drop_fields lists.
+func (f *FileConfig) keepDecoded(key string) bool {
+	if len(f.IncludeFields) > 0 && !containsString(f.IncludeFields, key) {
+		return false
+	}
+	return !containsString(f.DropFields, key)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeJSON(text string, fields map[string]string) error {
+	d := json.NewDecoder(strings.NewReader(text))
+	d.UseNumber()
+	var v map[string]interface{}
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("invalid json: %v", err)
+	}
+	for key, val := range v {
+		switch val := val.(type) {
+		case string:
+			fields[key] = val
+		case nil:
+			fields[key] = ""
+		default:
+			b, err := json.Marshal(val)
+			if err != nil {
+				return fmt.Errorf("unable to encode json field %s: %v", key, err)
+			}
+			fields[key] = string(b)
+		}
+	}
+	return nil
+}
+
+func decodeKV(text string, fields map[string]string) error {
+	pairs := make(map[string]string)
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimLeft(text, " \t") {
+		eq := strings.IndexAny(text, "= \t")
+		if eq <= 0 || text[eq] != '=' {
+			// not a key=value pair; skip the word.
+			if i := strings.IndexAny(text, " \t"); i > 0 {
+				text = text[i:]
+				continue
+			}
+			break
+		}
+		key := text[:eq]
+		text = text[eq+1:]
+
+		var val string
+		if strings.HasPrefix(text, `"`) {
+			var buf bytes.Buffer
+			i := 1
+			for ; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				buf.WriteByte(text[i])
+			}
+			if i == len(text) {
+				return fmt.Errorf("unterminated quoted value for key %s", key)
+			}
+			val, text = buf.String(), text[i+1:]
+		} else if i := strings.IndexAny(text, " \t"); i >= 0 {
+			val, text = text[:i], text[i:]
+		} else {
+			val, text = text, ""
+		}
+		pairs[key] = val
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("no key=value pairs found")
+	}
+	for k, v := range pairs {
+		fields[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	fields := make(map[string]string)
+	if err := codecJSON.decode(`{"level": "info", "count": 3, "ok": true, "tags": ["a"]}`, fields); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	expected := map[string]string{"level": "info", "count": "3", "ok": "true", "tags": `["a"]`}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Fatalf("field %s: expected %q, got %q", k, v, fields[k])
+		}
+	}
+
+	if err := codecJSON.decode(`not json`, fields); err == nil {
+		t.Fatalf("expected an error decoding invalid json")
+	}
+}
+
+func TestDecodeKV(t *testing.T) {
+	fields := make(map[string]string)
+	err := codecKV.decode(`ts=12:00 level=warn msg="disk \"sda\" full" stray user=bob`, fields)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	expected := map[string]string{"ts": "12:00", "level": "warn", "msg": `disk "sda" full`, "user": "bob"}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Fatalf("field %s: expected %q, got %q", k, v, fields[k])
+		}
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+
+	if err := codecKV.decode(`just some words`, make(map[string]string)); err == nil {
+		t.Fatalf("expected an error for a line without pairs")
+	}
+	if err := codecKV.decode(`msg="unterminated`, make(map[string]string)); err == nil {
+		t.Fatalf("expected an error for an unterminated quote")
+	}
+}
+
+func TestCodecFallback(t *testing.T) {
+	events := harvestString(&FileConfig{Codec: codecJSON}, "{\"a\": \"b\"}\nplain text\n")
+	if events[0].Fields["a"] != "b" || events[0].Fields["codec_error"] != "" {
+		t.Fatalf("unexpected fields for json line: %v", events[0].Fields)
+	}
+	if events[1].Text != "plain text" || events[1].Fields["codec_error"] == "" {
+		t.Fatalf("expected a codec_error for a plain line, got %v", events[1].Fields)
+	}
+}
+
+func TestCodecFieldFilters(t *testing.T) {
+	line := `{"level": "info", "msg": "hi", "password": "hunter2", "payload": "..."}` + "\n"
+	events := harvestString(&FileConfig{Codec: codecJSON, IncludeFields: []string{"level", "msg", "password"},
+		DropFields: []string{"password"}}, line)
+	f := events[0].Fields
+	if f["level"] != "info" || f["msg"] != "hi" {
+		t.Fatalf("expected level and msg to be kept, got %v", f)
+	}
+	if _, ok := f["password"]; ok {
+		t.Fatalf("expected password to be dropped, got %v", f)
+	}
+	if _, ok := f["payload"]; ok {
+		t.Fatalf("expected payload not to be included, got %v", f)
+	}
+}
+
+func TestContainerUnwrap(t *testing.T) {
+	docker := &containerSpec{Format: "docker"}
+	fields := make(map[string]string)
+	text, err := docker.unwrap(`{"log":"hello\n","stream":"stderr","time":"2014-01-01T00:00:00Z"}`, fields)
+	if err != nil || text != "hello" || fields["stream"] != "stderr" {
+		t.Fatalf("unexpected docker unwrap: %q %v %v", text, fields, err)
+	}
+
+	cri := &containerSpec{Format: "cri"}
+	fields = make(map[string]string)
+	text, err = cri.unwrap(`2016-10-06T00:17:09.669794202Z stdout P part of a line`, fields)
+	if err != nil || text != "part of a line" || fields["stream"] != "stdout" || fields["partial"] != "true" {
+		t.Fatalf("unexpected cri unwrap: %q %v %v", text, fields, err)
+	}
+}
+
+func TestPromoteTimestamp(t *testing.T) {
+	var conf FileConfig
+	err := json.Unmarshal([]byte(`{
+		"codec": "json",
+		"timestamp_field": "ts",
+		"timestamp_field_layout": "2006-01-02 15:04:05",
+		"timestamp_field_zone": "America/New_York"
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := harvestString(&conf, `{"ts": "2014-01-01 12:00:00"}`+"\n"+`{"ts": "yesterday"}`+"\n")
+	if ts := events[0].Fields["@timestamp"]; ts != "2014-01-01T17:00:00.000Z" {
+		t.Errorf("expected @timestamp in UTC, got %q", ts)
+	}
+	if events[1].Fields["timestamp_error"] == "" || events[1].Fields["@timestamp"] == "" {
+		t.Errorf("expected read time and a timestamp_error, got %v", events[1].Fields)
+	}
+
+	ts, err := parseFieldTime("1388577600500", "unix_ms", zone{})
+	if err != nil || !ts.Equal(time.Date(2014, 1, 1, 12, 0, 0, 5e8, time.UTC)) {
+		t.Errorf("unexpected unix_ms time %v, %v", ts, err)
+	}
+}
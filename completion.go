@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// a file can be harvested once, up to a point, and then left alone for good,
+// e.g. for a one-off bulk load of files that mustn't be tailed afterwards.
+// Its harvester completes, rather than stopping, once it has read
+// harvest_limit_bytes of the file, or has reached the end of a file last
+// modified harvest_until seconds ago.  It then waits for everything it sent
+// to be acknowledged, and has the registrar mark the file completed in the
+// progress file.  A completed file isn't harvested again, even after a
+// restart, unless it's replaced by a different file at the same path.
+
+// completion markers for the registrar to record.  They always go in the
+// progress file, even with -progress-store=xattr.
+var completions = make(chan *FileState, 16)
+
+// reports whether the harvester has read as much of its file as it's meant
+// to, at offset.  atEOF says whether it has read to the end of the file.
+func (h *Harvester) complete(offset int64, atEOF bool) bool {
+	if limit := h.conf.HarvestLimitBytes; limit > 0 && offset >= limit {
+		return true
+	}
+	if h.conf.HarvestUntil > 0 && atEOF {
+		info, err := h.file.Stat()
+		return err == nil && h.clock.Now().Sub(info.ModTime()).Seconds() >= float64(h.conf.HarvestUntil)
+	}
+	return false
+}
+
+// sends on what's left, waits for it all to be acknowledged, and then has
+// the file recorded as completed at offset.
+func (h *Harvester) markCompleted(offset int64) {
+	h.flush()
+	id, err := h.fileId()
+	if err != nil {
+		log.Printf("ERROR unable to mark %s completed: %v", h.Path, err)
+		return
+	}
+	h.waitAcknowledged(id, 0)
+	ino, dev := file_ids(h.fi)
+	completions <- &FileState{
+		Source:     h.Path,
+		Offset:     offset,
+		Inode:      ino,
+		Device:     dev,
+		Generation: generation(h.Path),
+		Completed:  true,
+	}
+	log.Printf("harvester for %s completed at offset %d", h.Path, offset)
+}
+
+// returned by waitAcknowledged when it gives up.
+var errUnacknowledged = errors.New("events sent are still unacknowledged")
+
+// waits until nothing the harvester has sent from the file with the given id
+// is waiting to be acknowledged, or, unless timeout is 0, until timeout has
+// passed, when it returns errUnacknowledged.
+func (h *Harvester) waitAcknowledged(id fileId, timeout time.Duration) error {
+	h.sendBatch()
+	// counted up from the time spent sleeping, as in readlines.
+	var waited time.Duration
+	for acks.unacknowledged(id) {
+		if timeout > 0 && waited >= timeout {
+			return fmt.Errorf("%w after %v", errUnacknowledged, timeout)
+		}
+		h.clock.Sleep(eofPoll)
+		waited += eofPoll
+	}
+	return nil
+}
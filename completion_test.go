@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// a harvester stops once it has read harvest_limit_bytes, and once what it
+// sent has been acknowledged, has the file marked completed.
+func TestHarvesterCompletes(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+	defer func(t *ackTracker) { acks = t }(acks)
+	acks = &ackTracker{files: make(map[fileId]*fileAcks)}
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntwo\nthree\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	h.conf.HarvestLimitBytes = 5
+	done := startReadlines(h, time.Minute)
+
+	var page eventPage
+	for _, expected := range []string{"one", "two"} {
+		e := <-out
+		if e.Text != expected {
+			t.Fatalf("expected %q, got %q", expected, e.Text)
+		}
+		page = append(page, e)
+	}
+	select {
+	case state := <-completions:
+		t.Fatalf("marked completed before being acknowledged: %+v", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+	acks.acknowledge(page)
+	select {
+	case state := <-completions:
+		if state.Source != h.Path || state.Offset != 8 || !state.Completed {
+			t.Fatalf("unexpected completion marker %+v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the completion marker")
+	}
+	<-done
+	select {
+	case e := <-out:
+		t.Fatalf("unexpected event %q after completing", e.Text)
+	default:
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// how much of the start of a line is compressed to estimate how well the
+// whole line will compress.
+const compressSample = 4096
+
+// the default compress_text_ratio.
+const defaultCompressRatio = 2.0
+
+// gzips text, if that looks worthwhile.  Compressing data that doesn't
+// compress, e.g. data that's already compressed, only burns CPU, so a sample
+// from the start of text is compressed first, and the whole of text only if
+// the sample shrank to at most 1/ratio of its size.  Returns the compressed
+// text, and whether it was compressed.
+func compressText(text string, ratio float64) (string, bool) {
+	if ratio <= 0 {
+		ratio = defaultCompressRatio
+	}
+	if len(text) > compressSample {
+		sample := gzipString(text[:compressSample])
+		if float64(compressSample) < ratio*float64(len(sample)) {
+			return text, false
+		}
+	}
+	compressed := gzipString(text)
+	if float64(len(text)) < ratio*float64(len(compressed)) {
+		return text, false
+	}
+	return compressed, true
+}
+
+func gzipString(s string) string {
+	var buf bytes.Buffer
+	z, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	z.Write([]byte(s))
+	z.Close()
+	return buf.String()
+}
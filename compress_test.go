@@ -0,0 +1,30 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompressText(t *testing.T) {
+	text := strings.Repeat("GET /index.html 200 ", 1000)
+	compressed, ok := compressText(text, 0)
+	if !ok {
+		t.Fatalf("compressible text wasn't compressed")
+	}
+	z, err := gzip.NewReader(strings.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(z); err != nil || string(b) != text {
+		t.Fatalf("compressed text didn't decompress to the original: %v", err)
+	}
+
+	random := make([]byte, 2*compressSample)
+	rand.Read(random)
+	if out, ok := compressText(string(random), 0); ok || out != string(random) {
+		t.Fatalf("incompressible text was compressed")
+	}
+}
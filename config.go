@@ -8,14 +8,28 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Network NetworkConfig `json:network`
-	Files   []FileConfig  `json:files`
+	Network NetworkConfig     `json:network`
+	Files   []FileConfig      `json:files`
+	Fields  map[string]string `json:"fields"`
+
+	// a JSON or YAML file of fields for every event, reloaded on SIGHUP.
+	// -fields-file overrides it.  See staticFields.
+	FieldsFile string `json:"fields_file"`
+
+	// if set, every event gets fields describing the lumberjack that sent
+	// it, named with this prefix.  See agentFields.
+	AgentFields string `json:"agent_fields"`
+
+	// if set, harvesters are started a few at a time.  See staggerSpec.
+	HarvesterStartupStagger *staggerSpec `json:"harvester_startup_stagger"`
 }
 
 func (c *Config) FileDest(path string) string {
@@ -33,7 +47,7 @@ func (c *Config) FileDest(path string) string {
 type NetworkConfig map[string]NetworkGroup
 
 func (n NetworkConfig) UnmarshalJSON(data []byte) error {
-	g := NetworkGroup{c_events: make(chan *FileEvent, 16), c_pages_unsent: make(chan eventPage)}
+	g := NetworkGroup{c_events: make(chan *FileEvent, 16), c_batches: make(chan []*FileEvent, 16), c_pages_unsent: make(chan eventPage)}
 	if err := json.Unmarshal(data, &g); err == nil {
 		if g.Name != "" && g.Name != "default" {
 			return fmt.Errorf("you cannot config a single network group with a name other than default")
@@ -65,6 +79,9 @@ func (n NetworkConfig) UnmarshalJSON(data []byte) error {
 		if g.c_events == nil {
 			g.c_events = make(chan *FileEvent, 16)
 		}
+		if g.c_batches == nil {
+			g.c_batches = make(chan []*FileEvent, 16)
+		}
 		if g.c_pages_unsent == nil {
 			g.c_pages_unsent = make(chan eventPage)
 		}
@@ -96,21 +113,68 @@ func (n NetworkConfig) EventChan(name string) chan *FileEvent {
 	return group.c_events
 }
 
+// the channel harvesters with emit_batch_size send batches of events to the
+// named network group on.
+func (n NetworkConfig) BatchChan(name string) chan []*FileEvent {
+	if name == "" {
+		name = "default"
+	}
+	group, ok := n[name]
+	if !ok {
+		log.Printf("ERROR unable to obtain batch channel for name: %v", name)
+		return nil
+	}
+	return group.c_batches
+}
+
 type NetworkGroup struct {
 	Name           string   `json:"name"`
 	Servers        []string `json:servers`
 	SSLCertificate string   `json:"ssl certificate"`
 	SSLKey         string   `json:"ssl key"`
 	SSLCA          string   `json:"ssl ca"`
+	CAFile         string   `json:"ca_file"`        // same as "ssl ca"
+	CAPath         string   `json:"ca_path"`        // directory of CA certificates
+	CASystem       bool     `json:"ca_system"`      // also trust the system's CAs
+	TLSServerName  string   `json:"tls_servername"` // name to use for SNI and certificate verification
 	Timeout        int64    `json:timeout`
 	timeout        time.Duration
+	RequireType    bool   `json:"require_type"`    // every file sent to this group must have a document type
+	MaxRetries     int    `json:"max_retries"`     // attempts at sending a page before it's dead lettered
+	SlowAckMs      int    `json:"slow_ack_ms"`     // average ack latency above which a server is slow
+	SlowAckPeriod  int    `json:"slow_ack_period"` // seconds a server must be slow for before it's avoided
+	DeadLetter     string `json:"dead_letter"`     // file dead lettered pages are appended to
+	Serialization  string `json:"serialization"`   // kv (the default) or msgpack
+	Workers        int    `json:"workers"`         // connections to each server
+	MaxEventAge    int    `json:"max_event_age"`   // seconds after being read that unsent events are dropped
+	Output         string `json:"output"`          // lumberjack (the default), or stdout
+	BatchOrder     string `json:"batch_order"`     // source or read_time to order each page's events
+	BatchSize      uint64 `json:"batch_size"`      // most events in a page, -spool-size if 0
+	MaxBatchBytes  int    `json:"max_batch_bytes"` // most bytes of data frames in a page, if set
+
+	Faults *faultConfig `json:"debug_faults"` // see faultConfig
 
-	c_events       chan *FileEvent // incoming file events
-	c_pages_unsent chan eventPage  // pages of events to be sent
+	c_events       chan *FileEvent   // incoming file events
+	c_batches      chan []*FileEvent // incoming batches of file events
+	c_pages_unsent chan eventPage    // pages of events to be sent
 }
 
 func (n *NetworkGroup) Spool() {
-	go Spool(n.c_events, n.c_pages_unsent, options.SpoolSize, options.IdleTimeout)
+	input, batches, output := n.c_events, n.c_batches, n.c_pages_unsent
+	supervise("spooler for "+n.Name, func() {
+		size := options.SpoolSize
+		if n.BatchSize > 0 {
+			size = n.BatchSize
+		}
+		Spool(input, batches, output, size, options.IdleTimeout, n.BatchOrder, n.MaxBatchBytes)
+	})
+}
+
+func (n *NetworkGroup) slowAckPeriod() time.Duration {
+	if n.SlowAckPeriod == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(n.SlowAckPeriod) * time.Second
 }
 
 func (n *NetworkGroup) TLS() (*tls.Config, error) {
@@ -124,24 +188,419 @@ func (n *NetworkGroup) TLS() (*tls.Config, error) {
 		}
 		c.Certificates = []tls.Certificate{cert}
 	}
-	c.RootCAs = x509.NewCertPool()
-	if n.SSLCA != "" {
-		raw, err := ioutil.ReadFile(n.SSLCA)
+	pool, err := n.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+	c.RootCAs = pool
+	// The address we dial may not be the name on the server's certificate,
+	// e.g. when the servers sit behind a load balancer.  Given the name and a
+	// CA to check it against, we can verify the server properly.
+	if n.TLSServerName != "" {
+		c.ServerName = n.TLSServerName
+		c.InsecureSkipVerify = !n.hasCAs()
+	}
+	return &c, nil
+}
+
+func (n *NetworkGroup) caFile() string {
+	if n.CAFile != "" {
+		return n.CAFile
+	}
+	return n.SSLCA
+}
+
+func (n *NetworkGroup) hasCAs() bool {
+	return n.caFile() != "" || n.CAPath != "" || n.CASystem
+}
+
+// the CAs servers' certificates are checked against: those in the CA file,
+// those in the files in the CA directory, and, with ca_system, the system's.
+// Without ca_system, only the given CAs are trusted.  Every file given must
+// hold at least one certificate, so that a bad path or a mangled bundle is
+// caught at startup rather than by every handshake failing.
+func (n *NetworkGroup) rootCAs() (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if n.CASystem {
+		system, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CAs: %v", err)
+		}
+		pool = system
+	}
+
+	var files []string
+	if f := n.caFile(); f != "" {
+		files = append(files, f)
+	}
+	if n.CAPath != "" {
+		infos, err := ioutil.ReadDir(n.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA directory: %v", err)
+		}
+		found := false
+		for _, info := range infos {
+			ext := filepath.Ext(info.Name())
+			if info.IsDir() || (ext != ".pem" && ext != ".crt") {
+				continue
+			}
+			files = append(files, filepath.Join(n.CAPath, info.Name()))
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no .pem or .crt files in CA directory %s", n.CAPath)
+		}
+	}
+
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
 		if err != nil {
 			return nil, fmt.Errorf("unable to read CA from file: %v", err)
 		}
-		if !c.RootCAs.AppendCertsFromPEM(raw) {
-			return nil, fmt.Errorf("illegal x509 CA")
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("illegal x509 CA: no PEM certificates found in %s", f)
 		}
 	}
-	return &c, nil
+	return pool, nil
 }
 
 type FileConfig struct {
-	Paths  []string          `json:paths`
-	Fields map[string]string `json:fields`
-	Join   joinspec          `json:join`
-	Dest   string            `json:"dest"`
+	Paths    []string          `json:paths`
+	Fields   map[string]string `json:fields`
+	Join     joinspec          `json:join`
+	Dest     string            `json:"dest"`
+	Rotation rotationMode      `json:"rotation"`
+
+	// lines matching RecordSeparator separate records: the lines between
+	// two separators are shipped as one event, and the separators aren't
+	// shipped.  An alternative to join.
+	RecordSeparator *pattern `json:"record_separator"`
+
+	// harvest lines written to connections to this unix socket, rather than
+	// files.  See harvestUnixSocket.
+	UnixSocket string `json:"unix_socket"`
+
+	// receive syslog messages at this address, tcp://host:port or
+	// udp://host:port, rather than harvesting files.  See harvestSyslog.
+	SyslogListen string `json:"syslog_listen"`
+
+	// DocumentType is added to every event as the TypeField field, "type" by
+	// default.  It takes precedence over a field of the same name in Fields.
+	DocumentType string `json:"document_type"`
+	TypeField    string `json:"type_field"`
+
+	// override -from-beginning for these files: read newly found files from
+	// the beginning, or tail them from the end.  Only one should be set.
+	FromBeginning *bool `json:"from_beginning"`
+	TailFiles     *bool `json:"tail_files"`
+
+	// of the files matched by each path, harvest only the one at this rank
+	// among the rotations of its base name: 0 for the active file, 1 for the
+	// most recently rotated, and so on.  See byRank.
+	RotationRank *int `json:"rotation_rank"`
+
+	// stop harvesters of files that haven't been modified in this many
+	// seconds, once they've read to the end, and start them again from
+	// where they stopped when the files are written to.  See closeInactive.
+	ActiveWindow int `json:"active_window"`
+
+	// when reading from the beginning, harvest newly found files one at a
+	// time, oldest rotation first, rather than all at once.
+	OrderedBackfill bool `json:"ordered_backfill"`
+
+	// when reading from the beginning, read no more than this many newly
+	// found files at a time until they reach their ends.  Files already
+	// being tailed don't count.  0 means no limit.
+	BackfillConcurrency int `json:"backfill_concurrency"`
+	backfillSlots       chan struct{}
+
+	// add file.owner_uid, file.owner_gid, file.owner and file.mode fields to
+	// every event, for the file it was read from.
+	OwnerFields bool `json:"owner_fields"`
+
+	// add a read_lag_bytes field to every event.  This costs a stat per
+	// event.
+	ReadLagField bool `json:"read_lag_field"`
+
+	// add a reopen field, set to true, to the first event after a file is
+	// opened, resumed, rewound or reopened.
+	ReopenField bool `json:"reopen_field"`
+
+	// harvest each file only up to HarvestLimitBytes, or until it was last
+	// modified HarvestUntil seconds ago, and then never again.  See
+	// completions.
+	HarvestLimitBytes int64 `json:"harvest_limit_bytes"`
+	HarvestUntil      int   `json:"harvest_until"`
+
+	// for paths with a ** directory, the most directories deep it goes, and
+	// how long each scan walks the tree for.  See treeWalk.
+	MaxDepth     int `json:"max_depth"`
+	ScanBudgetMs int `json:"scan_budget_ms"`
+	walks        map[string]*treeWalk
+
+	// add a hash of each event's text, with one of lineHashes, to the
+	// LineHashField field, line_hash by default.
+	LineHash      string `json:"line_hash"`
+	LineHashField string `json:"line_hash_field"`
+
+	// add a resumed field, set to true, to the first event after a file is
+	// resumed from the position recorded for it before a restart.
+	ResumeField bool `json:"resume_field"`
+
+	// send an event with event_type truncated whenever a file is rewound
+	// because it shrank.
+	TruncationEvents bool `json:"truncation_events"`
+
+	// add a rotation_generation field to every event.  See generations.
+	RotationGeneration bool `json:"rotation_generation"`
+
+	// end lines at a lone \r as well as at \n or \r\n, for files written
+	// on a mix of platforms.
+	MixedLineEndings bool `json:"mixed_line_endings"`
+
+	// the key each event's text is sent under, rather than line, which
+	// Logstash's lumberjack input renames to message.
+	MessageKey string `json:"message_key"`
+
+	// lines of header to skip when reading a file from the beginning
+	SkipLines int `json:"skip_lines"`
+
+	// split events with more text than this into several events.  See
+	// splitEvent.
+	MaxEventBytes int `json:"max_event_bytes"`
+
+	// send events to the spooler in batches of this many, rather than one
+	// at a time, to cut the cost of handing them over for busy files.  A
+	// batch is also sent whenever the harvester reaches the end of the file.
+	EmitBatchSize int `json:"emit_batch_size"`
+	batchOut      chan []*FileEvent
+
+	// ship the whole of each file as one event every time it changes, rather
+	// than tailing it.  See readWhole.
+	WholeFile           bool `json:"whole_file"`
+	WholeFileDebounceMs int  `json:"whole_file_debounce_ms"`
+
+	// set @timestamp of whole file events to the file's modification time.
+	WholeFileMtime bool `json:"whole_file_mtime"`
+
+	// size each harvester's read buffer to how fast its file is growing.
+	// See adaptReadAhead.
+	AdaptiveReadAhead bool `json:"adaptive_read_ahead"`
+
+	// how long to wait between attempts to open a file that can't be opened.
+	// See openRetrySpec.
+	OpenRetry *openRetrySpec `json:"open_retry"`
+
+	// how long to keep retrying a read that fails with a transient error,
+	// e.g. EINTR, in milliseconds, before treating it as fatal.  0 means a
+	// minute.  See retryReader.
+	ReadRetryMs int `json:"read_retry_ms"`
+
+	// read no more than this many bytes a second across all the files of
+	// this prospector.  0 means no limit.
+	MaxBytesPerSecond int `json:"max_bytes_per_second"`
+	readLimit         *rateLimiter
+
+	// once a file has been deleted and read to the end, keep reading it for
+	// this many milliseconds in case of late writes, before giving up on it.
+	GoneGraceMs int `json:"gone_grace_ms"`
+
+	// don't read from a file until it has gone unmodified for this many
+	// milliseconds.  This adds at least this much latency to every event.
+	MinAgeMs int `json:"min_age_ms"`
+
+	// events matching ErrorPattern get a context_before field holding the
+	// ContextBefore lines that came before them.
+	ErrorPattern  *pattern `json:"error_pattern"`
+	ContextBefore int      `json:"context_before"`
+
+	// events matching ErrorPattern are also sent to the ErrorDest network
+	// group, or only sent there with ErrorDestOnly.
+	ErrorDest     string `json:"error_dest"`
+	ErrorDestOnly bool   `json:"error_dest_only"`
+	errorOut      chan *FileEvent
+
+	// fields taken from segments of the path of each file, counted from 0,
+	// e.g. {"2": "service"} sets service to app for /var/log/app/out.log.
+	PathFields map[int]string `json:"path_fields"`
+
+	// a file in the directory of each log whose JSON object of fields is
+	// added to events from that log.  See sidecarFields.
+	FieldsFromSidecar string `json:"fields_from_sidecar"`
+
+	// gzip the text of events longer than CompressTextOver bytes, if a sample
+	// of it compresses by at least CompressTextRatio.  See compressText.
+	CompressTextOver  int     `json:"compress_text_over"`
+	CompressTextRatio float64 `json:"compress_text_ratio"`
+
+	// decompress files whose names end in .gz.  See gzipTail.
+	Gzip bool `json:"gzip"`
+
+	// set to "base64" to ship lines that aren't valid UTF-8 base64 encoded,
+	// with an encoding field saying so.
+	FallbackEncoding string `json:"fallback_encoding"`
+
+	// what to do with lines that aren't valid UTF-8: replace, drop, base64 or
+	// fail.  See encodingError.
+	EncodingError string `json:"encoding_error"`
+
+	// drop events whose timestamp is more than TimestampMaxAge seconds old.
+	// The timestamp is the first group of TimestampPattern, or the whole
+	// match if it has no groups, parsed with the Go time layout
+	// TimestampLayout.  See tooOld.
+	TimestampPattern *pattern `json:"timestamp_pattern"`
+	TimestampLayout  string   `json:"timestamp_layout"`
+	TimestampMaxAge  int      `json:"timestamp_max_age"`
+
+	// field transforms applied to every event.  See processor.
+	Processors []processor `json:"processors"`
+
+	// a Go plugin with a Transform function that every event is passed
+	// through, after the processors.  See transformPlugin.
+	TransformPlugin *transformPlugin `json:"transform_plugin"`
+
+	Container     *containerSpec `json:"container"`
+	Codec         codec          `json:"codec"`
+	CodecDropLine bool           `json:"codec_drop_line"` // don't ship the raw line once it's been decoded
+
+	// of the fields the codec decodes, ship only those in IncludeFields, if
+	// it's set, and none of those in DropFields.  See keepDecoded.
+	IncludeFields []string `json:"include_fields"`
+	DropFields    []string `json:"drop_fields"`
+
+	// set @timestamp from this field, as decoded by the codec or container.
+	// See promoteTimestamp.
+	TimestampField       string `json:"timestamp_field"`
+	TimestampFieldLayout string `json:"timestamp_field_layout"`
+	TimestampFieldZone   zone   `json:"timestamp_field_zone"`
+}
+
+// returns the i'th segment, counting from 0, of a slash separated path.
+func pathSegment(path string, i int) (string, bool) {
+	segments := strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/")
+	if i < 0 || i >= len(segments) {
+		return "", false
+	}
+	return segments[i], true
+}
+
+// openRetrySpec sets how a harvester retries opening its file.  The first
+// retry is BackoffMs after the failure, and each retry after that waits
+// BackoffFactor times longer than the one before, up to MaxBackoffMs.  Left
+// out, a file is retried every five seconds.
+type openRetrySpec struct {
+	BackoffMs     int     `json:"backoff_ms"`
+	BackoffFactor float64 `json:"backoff_factor"`
+	MaxBackoffMs  int     `json:"max_backoff_ms"`
+}
+
+// the wait before the first retry.
+func (s *openRetrySpec) first() time.Duration {
+	if s == nil || s.BackoffMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(s.BackoffMs) * time.Millisecond
+}
+
+// the wait before the retry after one that waited d.
+func (s *openRetrySpec) next(d time.Duration) time.Duration {
+	if s == nil || s.BackoffFactor <= 1 {
+		return d
+	}
+	d = time.Duration(float64(d) * s.BackoffFactor)
+	if s.MaxBackoffMs > 0 && d > time.Duration(s.MaxBackoffMs)*time.Millisecond {
+		d = time.Duration(s.MaxBackoffMs) * time.Millisecond
+	}
+	return d
+}
+
+// how long a read failing with a transient error is retried for.
+func (f *FileConfig) readRetry() time.Duration {
+	if f == nil || f.ReadRetryMs == 0 {
+		return defaultReadRetry
+	}
+	return time.Duration(f.ReadRetryMs) * time.Millisecond
+}
+
+// whether newly found files should be read from the beginning, rather than
+// from the end.
+func (f *FileConfig) fromBeginning() bool {
+	switch {
+	case f.FromBeginning != nil:
+		return *f.FromBeginning
+	case f.TailFiles != nil:
+		return !*f.TailFiles
+	}
+	return options.FromBeginning
+}
+
+func (f *FileConfig) typeField() string {
+	if f.TypeField == "" {
+		return "type"
+	}
+	return f.TypeField
+}
+
+// the document type of the events from these files, either set explicitly
+// or with a field.
+func (f *FileConfig) documentType() string {
+	if f.DocumentType != "" {
+		return f.DocumentType
+	}
+	return f.Fields[f.typeField()]
+}
+
+// rotationMode declares how the files of a prospector are rotated, so the
+// harvester knows which rotation heuristics to apply.
+//
+//   - create: files are renamed and a new file is created at the original
+//     path.  The harvester checks whether the path now refers to a different
+//     inode; a file shrinking is logged but never rewinds.
+//   - copytruncate: files are copied and then truncated in place.  The
+//     harvester checks for the file shrinking below its offset even while
+//     it's actively reading, not only at EOF, and rewinds.
+//   - auto: the default.  Apply every heuristic: inotify renames, logrotate
+//     temp file detection, and truncation checks at EOF.
+type rotationMode string
+
+const (
+	rotateAuto         rotationMode = "auto"
+	rotateCreate       rotationMode = "create"
+	rotateCopyTruncate rotationMode = "copytruncate"
+)
+
+func (r *rotationMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal rotation: %v", err)
+	}
+	switch m := rotationMode(s); m {
+	case "":
+		*r = rotateAuto
+	case rotateAuto, rotateCreate, rotateCopyTruncate:
+		*r = m
+	default:
+		return fmt.Errorf("illegal rotation mode: %q", s)
+	}
+	return nil
+}
+
+// pattern is a regular expression in the config file.
+type pattern struct {
+	*regexp.Regexp
+}
+
+func (p *pattern) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal pattern: %v", err)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("illegal pattern %q: %v", s, err)
+	}
+	p.Regexp = re
+	return nil
 }
 
 type joinspec []joinspecElem
@@ -233,15 +692,109 @@ func (j *shittyjoinspec) before(line string) bool {
 // attempts to load the configuration file.  If it's successful, it just exists
 // 0.  Otherwise, the error reason is printed to stderr and the program exits.
 func testConfig(path string) {
-	_, err := LoadConfig(path)
+	conf, err := LoadConfig(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "invalid config: %v", err)
 		os.Exit(1)
 	}
+	for _, path := range conf.emptyPaths() {
+		fmt.Fprintf(os.Stderr, "warning: path %s matches no files\n", path)
+	}
 	os.Exit(0)
 }
 
+// the paths of the config's files that match no files right now, which are
+// most likely wrong.
+func (c *Config) emptyPaths() []string {
+	var empty []string
+	for _, f := range c.Files {
+		for _, path := range f.Paths {
+			if path == "-" {
+				continue
+			}
+			// walked in full, with no scan budget.
+			whole := &FileConfig{MaxDepth: f.MaxDepth}
+			if _, n, err := whole.glob(path); err == nil && n == 0 {
+				empty = append(empty, path)
+			}
+		}
+	}
+	return empty
+}
+
+// loads the config from path, which may be a file, or a directory of .conf
+// files.  See mergeConfigs.
 func LoadConfig(path string) (*Config, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat config file '%s': %s\n", path, err)
+	}
+	var conf *Config
+	if fi.IsDir() {
+		conf, err = loadConfigDir(path)
+	} else {
+		conf, err = loadConfigFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return conf, nil
+}
+
+func loadConfigDir(dir string) (*Config, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config files in '%s': %s\n", dir, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .conf files in config directory '%s'\n", dir)
+	}
+	sort.Strings(paths)
+	conf := &Config{Network: make(NetworkConfig)}
+	for _, path := range paths {
+		part, err := loadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := conf.merge(part); err != nil {
+			return nil, fmt.Errorf("unable to merge config file '%s': %v", path, err)
+		}
+	}
+	return conf, nil
+}
+
+// merges another config file into c, for a config directory.  Files are
+// merged in name order.  The files of each are kept, and the network groups,
+// but a network group may only be defined once.  Global fields are merged,
+// with later files overriding earlier ones for the same field, and so are
+// the other settings, where they're set.
+func (c *Config) merge(other *Config) error {
+	for name, group := range other.Network {
+		if _, ok := c.Network[name]; ok {
+			return fmt.Errorf("network group %s is defined more than once", name)
+		}
+		c.Network[name] = group
+	}
+	c.Files = append(c.Files, other.Files...)
+	for k, v := range other.Fields {
+		if c.Fields == nil {
+			c.Fields = make(map[string]string)
+		}
+		c.Fields[k] = v
+	}
+	if other.AgentFields != "" {
+		c.AgentFields = other.AgentFields
+	}
+	if other.HarvesterStartupStagger != nil {
+		c.HarvesterStartupStagger = other.HarvesterStartupStagger
+	}
+	return nil
+}
+
+func loadConfigFile(path string) (*Config, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file '%s': %s\n", path, err)
@@ -259,7 +812,164 @@ func LoadConfig(path string) (*Config, error) {
 
 	conf := Config{Network: make(NetworkConfig)}
 	if err := json.NewDecoder(f).Decode(&conf); err != nil {
-		return nil, fmt.Errorf("failed unmarshalling config json: %s\n", err)
+		return nil, fmt.Errorf("failed unmarshalling config json in '%s': %s\n", path, err)
 	}
 	return &conf, nil
 }
+
+// checks that a message_key won't be sent twice in an event, once for the
+// text and once for a field.
+func (c *Config) checkMessageKey(f *FileConfig) error {
+	key := f.MessageKey
+	if key == "" {
+		return nil
+	}
+	switch key {
+	case "file", "host", "offset":
+		return fmt.Errorf("files %v: message_key %q is already used for the event's %s", f.Paths, key, key)
+	}
+	if _, ok := f.Fields[key]; ok {
+		return fmt.Errorf("files %v: message_key %q is also one of its fields", f.Paths, key)
+	}
+	if _, ok := c.Fields[key]; ok {
+		return fmt.Errorf("files %v: message_key %q is also one of the global fields", f.Paths, key)
+	}
+	for _, name := range f.PathFields {
+		if name == key {
+			return fmt.Errorf("files %v: message_key %q is also one of its path_fields", f.Paths, key)
+		}
+	}
+	return nil
+}
+
+// checks the parts of the config that depend on each other.
+func (c *Config) validate() error {
+	for name, group := range c.Network {
+		if group.Serialization != "" && group.Serialization != serializeKV && group.Serialization != serializeMsgpack {
+			return fmt.Errorf("network group %s has unknown serialization %s", name, group.Serialization)
+		}
+		if group.Output != "" && group.Output != "lumberjack" && group.Output != outputStdout {
+			return fmt.Errorf("network group %s has unknown output %s", name, group.Output)
+		}
+		if f := group.Faults; f != nil && (f.LatencyMs < 0 ||
+			f.AckFailure < 0 || f.AckFailure >= 1 || f.Disconnect < 0 || f.Disconnect >= 1) {
+			return fmt.Errorf("network group %s has invalid debug_faults: chances must be at least 0 and below 1", name)
+		}
+		if group.MaxBatchBytes < 0 {
+			return fmt.Errorf("network group %s: max_batch_bytes must not be negative", name)
+		}
+		if group.BatchOrder != "" && group.BatchOrder != orderSource && group.BatchOrder != orderReadTime {
+			return fmt.Errorf("network group %s has unknown batch_order %s", name, group.BatchOrder)
+		}
+	}
+	for _, f := range c.Files {
+		dest := f.Dest
+		if dest == "" {
+			dest = "default"
+		}
+		group, ok := c.Network[dest]
+		if !ok {
+			if f.Dest != "" {
+				// rather than have the prospector fail to start, and the
+				// files go unshipped, or to the wrong place.
+				return fmt.Errorf("files %v have unknown dest %s", f.Paths, f.Dest)
+			}
+			continue
+		}
+		if group.RequireType && f.documentType() == "" {
+			return fmt.Errorf("files %v have no document_type, required by network group %s", f.Paths, dest)
+		}
+	}
+	for _, f := range c.Files {
+		if f.FromBeginning != nil && f.TailFiles != nil {
+			return fmt.Errorf("files %v set both from_beginning and tail_files", f.Paths)
+		}
+		if f.RotationRank != nil && *f.RotationRank < 0 {
+			return fmt.Errorf("files %v: rotation_rank must not be negative", f.Paths)
+		}
+		if err := c.checkMessageKey(&f); err != nil {
+			return err
+		}
+		for _, path := range f.Paths {
+			if err := checkTreeGlob(path); err != nil {
+				return err
+			}
+		}
+		if f.HarvestLimitBytes < 0 || f.HarvestUntil < 0 {
+			return fmt.Errorf("files %v: harvest_limit_bytes and harvest_until must not be negative", f.Paths)
+		}
+		if f.MaxDepth < 0 || f.ScanBudgetMs < 0 {
+			return fmt.Errorf("files %v: max_depth and scan_budget_ms must not be negative", f.Paths)
+		}
+		if _, ok := lineHashes[f.LineHash]; f.LineHash != "" && !ok {
+			return fmt.Errorf("files %v have unknown line_hash %s", f.Paths, f.LineHash)
+		}
+		if f.WholeFileMtime && !f.WholeFile {
+			return fmt.Errorf("files %v set whole_file_mtime without whole_file", f.Paths)
+		}
+		if f.WholeFileMtime && f.TimestampField != "" {
+			return fmt.Errorf("files %v set both whole_file_mtime and timestamp_field", f.Paths)
+		}
+		if filepath.IsAbs(f.FieldsFromSidecar) {
+			return fmt.Errorf("files %v: fields_from_sidecar must be relative to the log files", f.Paths)
+		}
+		if f.EmitBatchSize < 0 {
+			return fmt.Errorf("files %v: emit_batch_size must not be negative", f.Paths)
+		}
+		if f.ActiveWindow < 0 {
+			return fmt.Errorf("files %v: active_window must not be negative", f.Paths)
+		}
+		if f.BackfillConcurrency < 0 {
+			return fmt.Errorf("files %v: backfill_concurrency must not be negative", f.Paths)
+		}
+		if f.ReadRetryMs < 0 {
+			return fmt.Errorf("files %v: read_retry_ms must not be negative", f.Paths)
+		}
+		if f.RecordSeparator != nil && len(f.Join) > 0 {
+			return fmt.Errorf("files %v set both join and record_separator", f.Paths)
+		}
+		if f.UnixSocket != "" && len(f.Paths) > 0 {
+			return fmt.Errorf("files %v set both paths and unix_socket %s", f.Paths, f.UnixSocket)
+		}
+		if f.SyslogListen != "" {
+			if len(f.Paths) > 0 || f.UnixSocket != "" {
+				return fmt.Errorf("files %v set syslog_listen %s as well as paths or unix_socket", f.Paths, f.SyslogListen)
+			}
+			if _, _, err := syslogAddr(f.SyslogListen); err != nil {
+				return err
+			}
+		}
+		if f.TimestampPattern != nil && (f.TimestampLayout == "" || f.TimestampMaxAge <= 0) {
+			return fmt.Errorf("files %v have a timestamp_pattern but no timestamp_layout or timestamp_max_age", f.Paths)
+		}
+		if f.FallbackEncoding != "" && f.FallbackEncoding != "base64" {
+			return fmt.Errorf("files %v have unknown fallback_encoding %s", f.Paths, f.FallbackEncoding)
+		}
+		if !validEncodingError(f.EncodingError) {
+			return fmt.Errorf("files %v have unknown encoding_error %s", f.Paths, f.EncodingError)
+		}
+		if f.EncodingError != "" && f.FallbackEncoding != "" {
+			return fmt.Errorf("files %v set both encoding_error and fallback_encoding", f.Paths)
+		}
+		for _, path := range f.Paths {
+			if path == "-" {
+				continue
+			}
+			for i, name := range f.PathFields {
+				if _, ok := pathSegment(path, i); !ok {
+					return fmt.Errorf("path %s has no segment %d for path field %s", path, i, name)
+				}
+			}
+		}
+		if f.ErrorDest == "" {
+			continue
+		}
+		if _, ok := c.Network[f.ErrorDest]; !ok {
+			return fmt.Errorf("files %v have unknown error_dest %s", f.Paths, f.ErrorDest)
+		}
+		if f.ErrorPattern == nil {
+			return fmt.Errorf("files %v have an error_dest but no error_pattern", f.Paths)
+		}
+	}
+	return nil
+}
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var source = []byte(`
@@ -31,3 +42,232 @@ func TestJSONLoading(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestRotationMode(t *testing.T) {
+	var f FileConfig
+	if err := json.Unmarshal([]byte(`{"rotation": "copytruncate"}`), &f); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if f.Rotation != rotateCopyTruncate {
+		t.Fatalf("unexpected rotation mode: %v", f.Rotation)
+	}
+	if err := json.Unmarshal([]byte(`{"rotation": "sideways"}`), &f); err == nil {
+		t.Fatalf("expected an error for an illegal rotation mode")
+	}
+}
+
+func TestRequireType(t *testing.T) {
+	conf := Config{Network: make(NetworkConfig)}
+	err := json.Unmarshal([]byte(`{
+		"network": {"servers": ["localhost:5043"], "require_type": true},
+		"files": [{"paths": ["/var/log/a.log"], "fields": {"type": "a"}},
+		          {"paths": ["/var/log/b.log"], "document_type": "b"}]
+	}`), &conf)
+	if err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	conf.Files = append(conf.Files, FileConfig{Paths: []string{"/var/log/c.log"}})
+	if err := conf.validate(); err == nil {
+		t.Fatalf("expected an error for files without a document type")
+	}
+}
+
+// each entry in files is sent to the network group named by its dest, which
+// must exist.
+func TestFileDest(t *testing.T) {
+	conf := Config{Network: make(NetworkConfig)}
+	err := json.Unmarshal([]byte(`{
+		"network": [{"servers": ["localhost:5043"]},
+		            {"name": "audit", "servers": ["secure:5043"]}],
+		"files": [{"paths": ["/var/log/app.log"]},
+		          {"paths": ["/var/log/audit.log"], "dest": "audit"}]
+	}`), &conf)
+	if err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if c := conf.Network.EventChan(conf.FileDest("/var/log/audit.log")); c == nil || c != conf.Network["audit"].c_events {
+		t.Fatalf("expected audit.log to be sent to the audit group")
+	}
+	if c := conf.Network.EventChan(conf.FileDest("/var/log/app.log")); c == nil || c != conf.Network["default"].c_events {
+		t.Fatalf("expected app.log to be sent to the default group")
+	}
+
+	conf.Files[1].Dest = "secure"
+	if err := conf.validate(); err == nil {
+		t.Fatalf("expected an error for an unknown dest")
+	}
+}
+
+func TestPathFields(t *testing.T) {
+	conf := Config{Network: make(NetworkConfig)}
+	err := json.Unmarshal([]byte(`{
+		"files": [{"paths": ["/var/log/*/app.log"], "path_fields": {"2": "service"}}]
+	}`), &conf)
+	if err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	h := newHarvester("/var/log/billing/app.log", &conf.Files[0], nil)
+	if h.Fields["service"] != "billing" {
+		t.Fatalf("expected service billing, got %v", h.Fields)
+	}
+
+	conf.Files[0].PathFields[4] = "nope"
+	if err := conf.validate(); err == nil {
+		t.Fatalf("expected an error for a missing path segment")
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	conf := Config{Network: make(NetworkConfig)}
+	err := json.Unmarshal([]byte(`{
+		"files": [{"paths": ["/var/log/a.log"], "message_key": "message", "fields": {"type": "a"}}]
+	}`), &conf)
+	if err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	events := harvestString(&conf.Files[0], "hello\n")
+	if events[0].messageKey() != "message" {
+		t.Fatalf("expected the text to be sent as message, got %s", events[0].messageKey())
+	}
+
+	conf.Files[0].MessageKey = "type"
+	if err := conf.validate(); err == nil {
+		t.Fatalf("expected an error for a message_key that's also a field")
+	}
+	conf.Files[0].MessageKey = "host"
+	if err := conf.validate(); err == nil {
+		t.Fatalf("expected an error for a message_key of host")
+	}
+}
+
+func TestFromBeginning(t *testing.T) {
+	defer func(b bool) { options.FromBeginning = b }(options.FromBeginning)
+	options.FromBeginning = true
+
+	for _, c := range []struct {
+		json     string
+		expected bool
+	}{
+		{`{}`, true},
+		{`{"tail_files": true}`, false},
+		{`{"from_beginning": false}`, false},
+		{`{"tail_files": false}`, true},
+	} {
+		var f FileConfig
+		if err := json.Unmarshal([]byte(c.json), &f); err != nil {
+			t.Fatalf("json.Unmarshal failed: %v", err)
+		}
+		if f.fromBeginning() != c.expected {
+			t.Fatalf("%s: expected fromBeginning %v", c.json, c.expected)
+		}
+	}
+}
+
+func TestRootCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "good.pem")
+	ioutil.WriteFile(good, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	bad := filepath.Join(dir, "bad.crt")
+	ioutil.WriteFile(bad, []byte("not a certificate"), 0644)
+
+	for _, c := range []struct {
+		group NetworkGroup
+		ok    bool
+	}{
+		{NetworkGroup{SSLCA: good}, true},
+		{NetworkGroup{CAFile: good}, true},
+		{NetworkGroup{CAFile: bad}, false},
+		{NetworkGroup{CAFile: filepath.Join(dir, "missing.pem")}, false},
+		{NetworkGroup{CAPath: dir}, false},
+		{NetworkGroup{CAPath: filepath.Join(dir, "missing")}, false},
+	} {
+		pool, err := c.group.rootCAs()
+		if c.ok && (err != nil || len(pool.Subjects()) != 1) {
+			t.Errorf("%+v: expected one CA, got %v", c.group, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%+v: expected an error", c.group)
+		}
+	}
+
+	os.Remove(bad)
+	if pool, err := (&NetworkGroup{CAPath: dir}).rootCAs(); err != nil || len(pool.Subjects()) != 1 {
+		t.Errorf("expected one CA from the directory, got %v", err)
+	}
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	parts := map[string]string{
+		"00-network.conf": `{"network": {"servers": ["localhost:5043"]}, "fields": {"env": "prod", "dc": "a"}}`,
+		"10-app.conf":     `{"files": [{"paths": ["/var/log/app.log"]}], "fields": {"dc": "b"}}`,
+		"20-web.conf":     `{"files": [{"paths": ["/var/log/web.log"]}]}`,
+		"notes.txt":       `not a config`,
+	}
+	for name, text := range parts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("unable to load config directory: %v", err)
+	}
+	if len(conf.Files) != 2 || conf.Files[0].Paths[0] != "/var/log/app.log" || conf.Files[1].Paths[0] != "/var/log/web.log" {
+		t.Fatalf("expected the files of both configs, in order, got %v", conf.Files)
+	}
+	if conf.Fields["env"] != "prod" || conf.Fields["dc"] != "b" {
+		t.Fatalf("expected fields merged with later files winning, got %v", conf.Fields)
+	}
+	if len(conf.Network["default"].Servers) != 1 {
+		t.Fatalf("expected the default network group, got %v", conf.Network)
+	}
+
+	dup := `{"network": {"servers": ["localhost:5044"]}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "30-dup.conf"), []byte(dup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(dir); err == nil {
+		t.Fatal("expected an error for a network group defined twice")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// containerSpec describes the format of container runtime log files, which
+// wrap each line the container wrote with the stream it was written to and a
+// timestamp.
+//
+//   - docker: one JSON object per line, {"log": ..., "stream": ..., "time": ...}
+//   - cri: "<time> <stream> <P|F> <log>", where P marks a partial line
+//
+// The wrapped line is shipped as the event's text, with stream and time
+// fields.  Any codec is applied to the unwrapped line.
+type containerSpec struct {
+	Format string `json:"format"`
+}
+
+func (c *containerSpec) UnmarshalJSON(b []byte) error {
+	type t containerSpec
+	var v t
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("cannot unmarshal container: %v", err)
+	}
+	switch v.Format {
+	case "docker", "cri":
+	default:
+		return fmt.Errorf("illegal container format: %q", v.Format)
+	}
+	*c = containerSpec(v)
+	return nil
+}
+
+// unwraps a container log line, adding its stream and time to fields.
+func (c *containerSpec) unwrap(text string, fields map[string]string) (string, error) {
+	switch c.Format {
+	case "docker":
+		var v struct {
+			Log    string `json:"log"`
+			Stream string `json:"stream"`
+			Time   string `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return text, fmt.Errorf("invalid docker log line: %v", err)
+		}
+		fields["stream"] = v.Stream
+		fields["time"] = v.Time
+		return strings.TrimRight(v.Log, "\r\n"), nil
+	case "cri":
+		parts := strings.SplitN(text, " ", 4)
+		if len(parts) < 3 {
+			return text, fmt.Errorf("invalid cri log line")
+		}
+		fields["time"] = parts[0]
+		fields["stream"] = parts[1]
+		if parts[2] == "P" {
+			fields["partial"] = "true"
+		}
+		if len(parts) == 3 {
+			return "", nil
+		}
+		return parts[3], nil
+	}
+	return text, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+)
+
+// lineRing keeps the last few lines read by a harvester, so that they can be
+// attached as context to an event that matches error_pattern.
+type lineRing struct {
+	lines []string
+	next  int  // index the next line will be written to
+	full  bool // whether lines has wrapped around
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+func (r *lineRing) push(line string) {
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// returns the lines in the ring, oldest first, joined with newlines.
+func (r *lineRing) String() string {
+	if !r.full {
+		return strings.Join(r.lines[:r.next], "\n")
+	}
+	ordered := make([]string, 0, len(r.lines))
+	ordered = append(ordered, r.lines[r.next:]...)
+	ordered = append(ordered, r.lines[:r.next]...)
+	return strings.Join(ordered, "\n")
+}
+
+func (r *lineRing) empty() bool {
+	return r.next == 0 && !r.full
+}
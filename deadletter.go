@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// a page of events that couldn't be sent is appended to the dead letter file,
+// one JSON object per event, so that the events can be inspected or replayed
+// later.
+type deadLetterEvent struct {
+	Time   time.Time         `json:"time"`
+	Error  string            `json:"error"`
+	Source string            `json:"source"`
+	Offset int64             `json:"offset"`
+	Line   string            `json:"line"`
+	Fields map[string]string `json:"fields"`
+}
+
+func writeDeadLetter(path string, page eventPage, cause error) error {
+	if path == "" {
+		return fmt.Errorf("no dead letter file configured")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open dead letter file: %v", err)
+	}
+	defer f.Close()
+
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	now := time.Now()
+	enc := json.NewEncoder(f)
+	for _, e := range page {
+		v := deadLetterEvent{
+			Time:   now,
+			Error:  reason,
+			Source: e.Source,
+			Offset: e.Offset,
+			Line:   e.Text,
+			Fields: e.Fields,
+		}
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("unable to write dead letter file: %v", err)
+		}
+	}
+	return nil
+}
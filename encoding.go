@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+)
+
+// what's done with lines that aren't valid UTF-8, chosen per entry in files
+// with "encoding_error".
+const (
+	encodingReplace = "replace" // invalid bytes become U+FFFD, the default
+	encodingDrop    = "drop"    // the line isn't shipped
+	encodingBase64  = "base64"  // the line is shipped base64 encoded
+	encodingFail    = "fail"    // the harvester stops at the line
+)
+
+func validEncodingError(policy string) bool {
+	switch policy {
+	case "", encodingReplace, encodingDrop, encodingBase64, encodingFail:
+		return true
+	}
+	return false
+}
+
+// the encoding_error policy, with fallback_encoding as the older way of
+// asking for base64.
+func (f *FileConfig) encodingError() string {
+	if f.EncodingError != "" {
+		return f.EncodingError
+	}
+	if f.FallbackEncoding == encodingBase64 {
+		return encodingBase64
+	}
+	return encodingReplace
+}
+
+// rewrites the text of e if it isn't valid UTF-8 and the policy says how.
+// Lines to be dropped, or failed on, are left for checkEncoding.
+func (h *Harvester) fixEncoding(e *FileEvent) {
+	if utf8.ValidString(e.Text) {
+		return
+	}
+	switch h.conf.encodingError() {
+	case encodingReplace:
+		// converting to runes turns each invalid byte into U+FFFD.
+		e.Text = string([]rune(e.Text))
+	case encodingBase64:
+		// invalid UTF-8 can't be carried in JSON, so would be mangled.
+		e.Text = base64.StdEncoding.EncodeToString([]byte(e.Text))
+		e.Fields["encoding"] = "base64"
+	}
+}
+
+// reports whether e should be shipped.  If the policy is to fail, the
+// harvester is also told to stop.
+func (h *Harvester) checkEncoding(e *FileEvent) bool {
+	if utf8.ValidString(e.Text) {
+		return true
+	}
+	switch h.conf.encodingError() {
+	case encodingDrop:
+		return false
+	case encodingFail:
+		h.encodingErr = fmt.Errorf("invalid UTF-8 in line at offset %d", e.Offset)
+		return false
+	}
+	return true
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"time"
 )
 
 // type FileEvent represents a single event in a log file.  I.e., it represents
@@ -17,6 +18,18 @@ type FileEvent struct {
 	Rotated bool
 
 	fileinfo os.FileInfo
+	length   int64     // bytes of the file this event was read from
+	readAt   time.Time // when the event was read.  See dropStale.
+	textKey  string    // the key Text is sent under, if not line
+	epoch    int       // see ackTracker.rewound
+}
+
+// the key the event's text is sent under.
+func (e *FileEvent) messageKey() string {
+	if e.textKey == "" {
+		return "line"
+	}
+	return e.textKey
 }
 
 func (e *FileEvent) writeFrame(w io.Writer, id uint32) {
@@ -27,12 +40,28 @@ func (e *FileEvent) writeFrame(w io.Writer, id uint32) {
 	writeKV("file", e.Source, w)
 	writeKV("host", hostname, w)
 	writeKV("offset", strconv.FormatInt(e.Offset, 10), w)
-	writeKV("line", e.Text, w)
+	writeKV(e.messageKey(), e.Text, w)
 	for k, v := range e.Fields {
 		writeKV(k, v, w)
 	}
 }
 
+// the number of bytes e takes up in a data frame.  See writeFrame.
+func (e *FileEvent) frameSize() int {
+	size := 2 + 4 + 4 // version, frame type, sequence and pair count
+	kv := func(k, v string) {
+		size += 4 + len(k) + 4 + len(v)
+	}
+	kv("file", e.Source)
+	kv("host", hostname)
+	kv("offset", strconv.FormatInt(e.Offset, 10))
+	kv(e.messageKey(), e.Text)
+	for k, v := range e.Fields {
+		kv(k, v)
+	}
+	return size
+}
+
 func writeKV(key string, value string, output io.Writer) {
 	binary.Write(output, binary.BigEndian, uint32(len(key)))
 	output.Write([]byte(key))
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// the format @timestamp is shipped in, which Logstash takes as the event's
+// time.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// a time zone given by name in the config, e.g. "Europe/London".
+type zone struct {
+	*time.Location
+}
+
+func (z *zone) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal zone: %v", err)
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return fmt.Errorf("illegal time zone %q: %v", s, err)
+	}
+	z.Location = loc
+	return nil
+}
+
+// sets the @timestamp field of e from its timestamp_field, as decoded by its
+// codec, so that Logstash uses the time the application logged rather than
+// the time the event arrived.  If the field is missing or can't be parsed,
+// @timestamp is set to when the line was read, and a timestamp_error field
+// says why.
+func (h *Harvester) promoteTimestamp(e *FileEvent) {
+	conf := h.conf
+	var t time.Time
+	v, ok := e.Fields[conf.TimestampField]
+	err := fmt.Errorf("no %s field", conf.TimestampField)
+	if ok {
+		t, err = parseFieldTime(v, conf.TimestampFieldLayout, conf.TimestampFieldZone)
+	}
+	if err != nil {
+		e.Fields["timestamp_error"] = err.Error()
+		t = h.clock.Now()
+	}
+	e.Fields["@timestamp"] = t.UTC().Format(timestampFormat)
+}
+
+// parses a time with the given Go time layout, RFC 3339 if there isn't one,
+// or as seconds or milliseconds since the epoch with "unix" or "unix_ms".
+// Times without a zone are taken to be in z, or local time if z isn't set.
+func parseFieldTime(s, layout string, z zone) (time.Time, error) {
+	switch layout {
+	case "":
+		layout = time.RFC3339Nano
+	case "unix", "unix_ms":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s time %q", layout, s)
+		}
+		if layout == "unix_ms" {
+			f /= 1000
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	}
+	loc := z.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %v", s, err)
+	}
+	return t, nil
+}
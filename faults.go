@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// faults a network group injects into its connections to servers, to see
+// how the publishers and registrar cope with a slow or flaky Logstash
+// without needing one: writes are delayed, acks fail to arrive, and
+// connections drop.  Set with "debug_faults" on a network group; it's meant
+// for tests, so isn't documented.
+type faultConfig struct {
+	LatencyMs  int     `json:"latency_ms"`  // added before every write
+	AckFailure float64 `json:"ack_failure"` // chance of each read failing
+	Disconnect float64 `json:"disconnect"`  // chance of each write dropping the connection
+	Seed       int64   `json:"seed"`        // for repeatable faults, if set
+}
+
+var (
+	errInjectedAck        = errors.New("injected ack failure")
+	errInjectedDisconnect = errors.New("injected disconnect")
+)
+
+func (f *faultConfig) enabled() bool {
+	return f != nil && (f.LatencyMs > 0 || f.AckFailure > 0 || f.Disconnect > 0)
+}
+
+// the random source for the faults of the publisher with the given id.
+func (f *faultConfig) source(id int) *rand.Rand {
+	seed := f.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed + int64(id)))
+}
+
+// a connection that misbehaves as faults says.  Only its publisher uses it,
+// so rand isn't shared.
+type faultConn struct {
+	net.Conn
+	faults *faultConfig
+	rand   *rand.Rand
+}
+
+func (c *faultConn) chance(p float64) bool {
+	return p > 0 && c.rand.Float64() < p
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	if c.faults.LatencyMs > 0 {
+		time.Sleep(time.Duration(c.faults.LatencyMs) * time.Millisecond)
+	}
+	if c.chance(c.faults.Disconnect) {
+		c.Conn.Close()
+		return 0, errInjectedDisconnect
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.chance(c.faults.AckFailure) {
+		return 0, errInjectedAck
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFaultConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		b := make([]byte, 16)
+		for {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			server.Write(b[:n])
+		}
+	}()
+
+	faults := &faultConfig{LatencyMs: 20, Seed: 1}
+	c := &faultConn{Conn: client, faults: faults, rand: faults.source(0)}
+	start := time.Now()
+	if _, err := c.Write([]byte("1W")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected the write to be delayed, took %v", elapsed)
+	}
+	b := make([]byte, 2)
+	if _, err := c.Read(b); err != nil || string(b) != "1W" {
+		t.Fatalf("expected the echoed write, got %q, %v", b, err)
+	}
+
+	faults.LatencyMs, faults.AckFailure = 0, 0.999
+	if _, err := c.Read(b); err != errInjectedAck {
+		t.Fatalf("expected an injected ack failure, got %v", err)
+	}
+
+	faults.Disconnect = 0.999
+	if _, err := c.Write([]byte("1W")); err != errInjectedDisconnect {
+		t.Fatalf("expected an injected disconnect, got %v", err)
+	}
+	if _, err := client.Write([]byte("1W")); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestFaultsSeed(t *testing.T) {
+	faults := &faultConfig{Seed: 42}
+	a, b := faults.source(1), faults.source(1)
+	for i := 0; i < 10; i++ {
+		if a.Float64() != b.Float64() {
+			t.Fatal("expected the same faults from the same seed")
+		}
+	}
+}
+
+func TestFaultsConfig(t *testing.T) {
+	conf := Config{Network: make(NetworkConfig)}
+	err := json.Unmarshal([]byte(`{
+		"network": {"servers": ["localhost:5043"],
+		            "debug_faults": {"latency_ms": 50, "ack_failure": 0.1}}
+	}`), &conf)
+	if err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if !conf.Network["default"].Faults.enabled() {
+		t.Fatal("expected faults to be enabled")
+	}
+
+	conf.Network["default"].Faults.AckFailure = 1
+	if err := conf.validate(); err == nil {
+		t.Fatal("expected an error for acks that always fail")
+	}
+}
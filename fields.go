@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// static fields are merged into every event.  There are three sources of
+// fields, and when the same key appears in more than one of them, the more
+// specific source wins:
+//
+//	prospector "fields" > fields file (fields_file) > global config "fields"
+//
+// The fields file is intended for volatile host metadata (datacenter, rack,
+// team) that is maintained outside of the main config.  It is reloaded on
+// SIGHUP.
+var staticFields struct {
+	sync.RWMutex
+	global map[string]string // "fields" at the top level of the config file
+	file   map[string]string // contents of the fields file
+}
+
+func setGlobalFields(fields map[string]string) {
+	staticFields.Lock()
+	defer staticFields.Unlock()
+	staticFields.global = fields
+}
+
+// loads a flat JSON object of string keys and values from the file at path,
+// or a flat YAML mapping if its name ends in .yml or .yaml.
+func loadFieldsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open fields file: %v", err)
+	}
+	defer f.Close()
+
+	var fields map[string]string
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		fields, err = decodeFlatYAML(f)
+	default:
+		err = json.NewDecoder(f).Decode(&fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode fields file %s: %v", path, err)
+	}
+	return fields, nil
+}
+
+// decodes a YAML mapping of keys to plain or quoted scalars, one per line:
+//
+//	datacenter: us-east-1  # comments are fine
+//	team: "payments"
+//
+// which is all a fields file needs.  Nested mappings, lists, anchors and
+// multi-line values are refused rather than misread.
+func decodeFlatYAML(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if trimmed != line[:len(trimmed)] {
+			return nil, fmt.Errorf("line %d: nested values aren't supported", n)
+		}
+		i := strings.Index(line, ":")
+		if i < 0 || (i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t') {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key, err := yamlScalar(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		value, err := yamlScalar(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		fields[key] = value
+	}
+	return fields, scanner.Err()
+}
+
+// the string a plain or quoted YAML scalar stands for.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		end := strings.LastIndex(s, "\"")
+		if end == 0 || !yamlComment(s[end+1:]) {
+			return "", fmt.Errorf("bad quoted value %s", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	case s[0] == '\'':
+		end := strings.LastIndex(s, "'")
+		if end == 0 || !yamlComment(s[end+1:]) {
+			return "", fmt.Errorf("bad quoted value %s", s)
+		}
+		return strings.Replace(s[1:end], "''", "'", -1), nil
+	case strings.ContainsRune("[{|>&*!%@`", rune(s[0])):
+		return "", fmt.Errorf("value %s isn't a plain string", s)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// reports whether what follows a quoted scalar is nothing but a comment.
+func yamlComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// (re)reads the fields file, from -fields-file or the config's fields_file.
+// If the file can't be read, the previously loaded fields are kept.
+func reloadFieldsFile() error {
+	if options.FieldsFile == "" {
+		return nil
+	}
+	fields, err := loadFieldsFile(options.FieldsFile)
+	if err != nil {
+		return err
+	}
+	staticFields.Lock()
+	defer staticFields.Unlock()
+	staticFields.file = fields
+	log.Printf("loaded %d fields from %s", len(fields), options.FieldsFile)
+	return nil
+}
+
+// mergeFields returns a new map containing the global fields, the fields file
+// fields, and the given prospector fields, in increasing order of precedence.
+func mergeFields(prospector map[string]string) map[string]string {
+	staticFields.RLock()
+	defer staticFields.RUnlock()
+
+	merged := make(map[string]string,
+		len(staticFields.global)+len(staticFields.file)+len(prospector)+1)
+	for k, v := range staticFields.global {
+		merged[k] = v
+	}
+	for k, v := range staticFields.file {
+		merged[k] = v
+	}
+	for k, v := range prospector {
+		merged[k] = v
+	}
+	return merged
+}
+
+// guards the Fields of the running prospectors' configs and of harvesters,
+// which are reloaded from the config file on SIGHUP.  Reloading replaces the
+// maps rather than modifying them, so a map read under the lock can still be
+// used once it's been released.
+var fieldsLock sync.RWMutex
+
+// the configs of the running prospectors.  Guarded by fieldsLock.
+var prospectorConfs []*FileConfig
+
+func addProspectorConf(conf *FileConfig) {
+	fieldsLock.Lock()
+	defer fieldsLock.Unlock()
+	prospectorConfs = append(prospectorConfs, conf)
+}
+
+// rereads the config file and applies any changes to the "fields" of the
+// running prospectors, matched up by their paths.  Harvesters carry on from
+// where they are, and events read from then on get the new fields.  Any
+// other changes to the config only take effect on restart.
+func reloadConfigFields() error {
+	if options.ConfigFile == "" {
+		return nil
+	}
+	config, err := LoadConfig(options.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	// taken before fieldsLock, which the registry's String method takes
+	// while holding the registry's lock.
+	var harvesters []*Harvester
+	registry.RLock()
+	for _, h := range registry.RunningIds {
+		harvesters = append(harvesters, h)
+	}
+	registry.RUnlock()
+
+	fieldsLock.Lock()
+	defer fieldsLock.Unlock()
+	changed := make(map[*FileConfig]bool)
+	for _, f := range config.Files {
+		for _, conf := range prospectorConfs {
+			if reflect.DeepEqual(conf.Paths, f.Paths) && !reflect.DeepEqual(conf.Fields, f.Fields) {
+				log.Printf("reloading fields of %v", conf.Paths)
+				conf.Fields = f.Fields
+				changed[conf] = true
+			}
+		}
+	}
+	for _, h := range harvesters {
+		if changed[h.conf] {
+			h.setFields(h.conf.Fields)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFieldsPrecedence(t *testing.T) {
+	defer setGlobalFields(nil)
+	defer func() { staticFields.file = nil }()
+
+	setGlobalFields(map[string]string{"dc": "global", "rack": "global", "team": "global"})
+	staticFields.file = map[string]string{"rack": "file", "team": "file"}
+
+	fields := mergeFields(map[string]string{"team": "prospector"})
+	if fields["dc"] != "global" {
+		t.Fatalf("expected dc from global fields, got %q", fields["dc"])
+	}
+	if fields["rack"] != "file" {
+		t.Fatalf("expected fields file to override global fields, got %q", fields["rack"])
+	}
+	if fields["team"] != "prospector" {
+		t.Fatalf("expected prospector fields to override fields file, got %q", fields["team"])
+	}
+}
+
+func TestMergeFieldsCopies(t *testing.T) {
+	prospector := map[string]string{"type": "syslog"}
+	fields := mergeFields(prospector)
+	fields["rotated"] = "true"
+	if _, ok := prospector["rotated"]; ok {
+		t.Fatalf("mergeFields modified the prospector fields")
+	}
+}
+
+func TestReloadFieldsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"datacenter": "us-east-1"}`)
+	f.Close()
+
+	defer func(prev string) { options.FieldsFile = prev }(options.FieldsFile)
+	defer func() { staticFields.file = nil }()
+	options.FieldsFile = f.Name()
+
+	if err := reloadFieldsFile(); err != nil {
+		t.Fatalf("reloadFieldsFile failed: %v", err)
+	}
+	if v := mergeFields(nil)["datacenter"]; v != "us-east-1" {
+		t.Fatalf("expected datacenter from fields file, got %q", v)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("not json"), 0644)
+	if err := reloadFieldsFile(); err == nil {
+		t.Fatalf("expected an error reloading an invalid fields file")
+	}
+	if v := mergeFields(nil)["datacenter"]; v != "us-east-1" {
+		t.Fatalf("expected previous fields to be kept after a failed reload, got %q", v)
+	}
+}
+
+// events built before a harvester's fields are reloaded keep the old fields.
+func TestHarvesterSetFields(t *testing.T) {
+	h := newReaderHarvester("test", nil, &FileConfig{Fields: map[string]string{"team": "old"}}, nil)
+	before := h.event("one", 0, nil)
+
+	fieldsLock.Lock()
+	h.setFields(map[string]string{"team": "new"})
+	fieldsLock.Unlock()
+	after := h.event("two", 4, nil)
+
+	if before.Fields["team"] != "old" || after.Fields["team"] != "new" {
+		t.Fatalf("expected old then new fields, got %v then %v", before.Fields, after.Fields)
+	}
+}
+
+func TestFieldsFileYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fields.yml")
+	ioutil.WriteFile(path, []byte(`---
+# host metadata
+datacenter: us-east-1  # the region
+team: "pay: ments"
+owner: 'o''neil'
+port: 8080
+`), 0644)
+
+	fields, err := loadFieldsFile(path)
+	if err != nil {
+		t.Fatalf("loadFieldsFile failed: %v", err)
+	}
+	expected := map[string]string{"datacenter": "us-east-1", "team": "pay: ments", "owner": "o'neil", "port": "8080"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected %v, got %v", expected, fields)
+	}
+
+	for _, bad := range []string{"rack:\n  row: 3\n", "tags: [a, b]\n", "just text\n"} {
+		ioutil.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadFieldsFile(path); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestConfigFieldsFile(t *testing.T) {
+	var conf Config
+	if err := json.Unmarshal([]byte(`{"fields_file": "/etc/lumberjack/fields.yml"}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.FieldsFile != "/etc/lumberjack/fields.yml" {
+		t.Fatalf("expected fields_file to be loaded, got %q", conf.FieldsFile)
+	}
+}
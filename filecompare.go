@@ -9,7 +9,7 @@ import (
 
 func is_file_same(path string, info os.FileInfo, state *FileState) bool {
 	fstat := info.Sys().(*syscall.Stat_t)
-	return (fstat.Ino == state.Inode && fstat.Dev == state.Device)
+	return (fstat.Ino == state.Inode && fstat.Dev == state.Device && sameBirth(info, state))
 }
 
 func is_fileinfo_same(a os.FileInfo, b os.FileInfo) bool {
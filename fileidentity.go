@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// how files are told apart while they're being harvested, chosen with
+// -file-identity.  The key identifies harvesters in the registry, and the
+// files whose events are waiting to be acknowledged.  The device and inode
+// are enough, unless inodes are reused quickly, e.g. on a busy filesystem
+// where rotated files are deleted and new ones created straight away: then
+// a new file can be taken for a deleted one that's still being harvested, or
+// resumed from a position recorded for the old one.  inode_birth adds the
+// time the file was created, on platforms that record it, so a reused inode
+// gets a different key.
+var fileIdentities = map[string]func(os.FileInfo) string{
+	"inode":       inodeKey,
+	"inode_birth": inodeBirthKey,
+}
+
+var fileIdentity = inodeKey
+
+// whether positions recorded in the progress file carry the birth time of
+// their files, to check on resume.
+var recordBirth bool
+
+func setFileIdentity(name string) error {
+	key, ok := fileIdentities[name]
+	if !ok {
+		return fmt.Errorf("invalid -file-identity %q: must be inode or inode_birth", name)
+	}
+	if name == "inode_birth" && !birthTimes {
+		return fmt.Errorf("-file-identity inode_birth isn't supported here: stat doesn't report when files were created")
+	}
+	fileIdentity = key
+	recordBirth = name == "inode_birth"
+	return nil
+}
+
+// stats the file at path.  Infos that files are told apart by must come from
+// here or statOpen, so that with inode_birth they carry the file's birth time
+// where stat doesn't report it.  See addBirth.
+func statFile(path string) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil || !recordBirth {
+		return info, err
+	}
+	return addBirth(info, path, nil), nil
+}
+
+// stats the open file f.  See statFile.
+func statOpen(f *os.File) (os.FileInfo, error) {
+	info, err := f.Stat()
+	if err != nil || !recordBirth {
+		return info, err
+	}
+	return addBirth(info, f.Name(), f), nil
+}
+
+func filestring(info os.FileInfo) fileId {
+	return fileId(fileIdentity(info))
+}
+
+func inodeKey(info os.FileInfo) string {
+	ino, dev := file_ids(info)
+	return fmt.Sprintf("%v_%v", ino, dev)
+}
+
+func inodeBirthKey(info os.FileInfo) string {
+	t, ok := birthTime(info)
+	if !ok {
+		return inodeKey(info)
+	}
+	return fmt.Sprintf("%s_%d", inodeKey(info), t.UnixNano())
+}
+
+// the birth time to record in the progress file for the file with info, or
+// 0 if there's none to record.
+func birthStamp(info os.FileInfo) int64 {
+	if !recordBirth {
+		return 0
+	}
+	if t, ok := birthTime(info); ok {
+		return t.UnixNano()
+	}
+	return 0
+}
+
+// reports whether the file with info was created when state says, if it says.
+func sameBirth(info os.FileInfo, state *FileState) bool {
+	if state.Birth == 0 {
+		return true
+	}
+	t, ok := birthTime(info)
+	return !ok || t.UnixNano() == state.Birth
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileIdentity(t *testing.T) {
+	defer setFileIdentity("inode")
+
+	f, err := ioutil.TempFile("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setFileIdentity("path"); err == nil {
+		t.Fatal("expected an unknown identity to be refused")
+	}
+	if err := setFileIdentity("inode"); err != nil {
+		t.Fatal(err)
+	}
+	if id := filestring(info); string(id) != inodeKey(info) {
+		t.Fatalf("expected the inode key, got %s", id)
+	}
+	if birthStamp(info) != 0 {
+		t.Fatal("expected no birth time to be recorded by inode")
+	}
+
+	err = setFileIdentity("inode_birth")
+	if !birthTimes {
+		if err == nil {
+			t.Fatal("expected inode_birth to be refused without birth times")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err = statFile(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := birthTime(info); !ok {
+		t.Skip("the filesystem doesn't record when files were created")
+	}
+	state := &FileState{Birth: birthStamp(info)}
+	if state.Birth == 0 || !sameBirth(info, state) {
+		t.Fatalf("expected the file's birth time to be recorded and match, got %d", state.Birth)
+	}
+	state.Birth++
+	if sameBirth(info, state) {
+		t.Fatal("expected a different birth time not to match")
+	}
+
+	// an open file is told apart the same way as its path.
+	if id := filestring(info); string(id) == inodeKey(info) {
+		t.Fatalf("expected the birth time in the key, got %s", id)
+	}
+	opened, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	openInfo, err := statOpen(opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filestring(openInfo) != filestring(info) {
+		t.Fatalf("expected the same key for the open file, got %s and %s", filestring(openInfo), filestring(info))
+	}
+}
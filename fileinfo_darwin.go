@@ -1,17 +1,24 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"syscall"
+	"time"
 )
 
+const birthTimes = true
+
 func file_ids(info os.FileInfo) (uint64, int32) {
 	fstat := info.Sys().(*syscall.Stat_t)
 	return fstat.Ino, fstat.Dev
 }
 
-func filestring(info os.FileInfo) fileId {
-	stat := info.Sys().(*syscall.Stat_t)
-	return fileId(fmt.Sprintf("%v_%v", stat.Ino, stat.Dev))
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	fstat := info.Sys().(*syscall.Stat_t)
+	return time.Unix(fstat.Birthtimespec.Sec, fstat.Birthtimespec.Nsec), true
+}
+
+// stat reports birth times already.
+func addBirth(info os.FileInfo, path string, f *os.File) os.FileInfo {
+	return info
 }
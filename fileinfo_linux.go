@@ -1,18 +1,107 @@
 package main
 
 import (
-	"fmt"
 	"os"
+	"runtime"
 	"syscall"
+	"time"
+	"unsafe"
 )
 
+// linux's stat doesn't report when a file was created, but statx does, on
+// kernels and filesystems that keep it.  See addBirth.
+const birthTimes = true
+
 func file_ids(info os.FileInfo) (uint64, uint64) {
 	fstat := info.Sys().(*syscall.Stat_t)
 
 	return fstat.Ino, fstat.Dev
 }
 
-func filestring(info os.FileInfo) fileId {
-	stat := info.Sys().(*syscall.Stat_t)
-	return fileId(fmt.Sprintf("%v_%v", stat.Ino, stat.Dev))
+// a file's info, along with when it was created.
+type birthInfo struct {
+	os.FileInfo
+	birth time.Time
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	if b, ok := info.(birthInfo); ok {
+		return b.birth, true
+	}
+	return time.Time{}, false
+}
+
+// adds to info, from the file at path, or f if it's open, when the file was
+// created.  info is returned as it is if statx isn't supported, or the
+// filesystem doesn't keep birth times, or path is no longer the same file.
+func addBirth(info os.FileInfo, path string, f *os.File) os.FileInfo {
+	var stx statxBuf
+	var err error
+	if f != nil {
+		var rc syscall.RawConn
+		if rc, err = f.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) {
+				err = statx(int(fd), "", _AT_EMPTY_PATH, &stx)
+			})
+		}
+	} else {
+		err = statx(_AT_FDCWD, path, 0, &stx)
+	}
+	if err != nil || stx.Mask&_STATX_BTIME == 0 || stx.Ino != info.Sys().(*syscall.Stat_t).Ino {
+		return info
+	}
+	return birthInfo{info, time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))}
+}
+
+// what's needed of statx(2), which the syscall package doesn't have.
+const (
+	_AT_FDCWD      = -0x64
+	_AT_EMPTY_PATH = 0x1000
+	_STATX_BTIME   = 0x800
+)
+
+// statx's system call number, by architecture.
+var sysStatx = map[string]uintptr{
+	"386": 383, "amd64": 332, "arm": 397, "arm64": 291, "loong64": 291,
+	"mips": 4366, "mipsle": 4366, "mips64": 5326, "mips64le": 5326,
+	"ppc64": 383, "ppc64le": 383, "riscv64": 291, "s390x": 379,
+}
+
+// struct statx, up to the fields used.
+type statxBuf struct {
+	Mask            uint32
+	Blksize         uint32
+	Attributes      uint64
+	Nlink, Uid, Gid uint32
+	Mode            uint16
+	_               uint16
+	Ino, Size       uint64
+	Blocks          uint64
+	AttributesMask  uint64
+	Atime, Btime    statxTimestamp
+	Ctime, Mtime    statxTimestamp
+	_               [128]byte
+}
+
+type statxTimestamp struct {
+	Sec  int64
+	Nsec uint32
+	_    int32
+}
+
+func statx(dirfd int, path string, flags int, stx *statxBuf) error {
+	nr, ok := sysStatx[runtime.GOARCH]
+	if !ok {
+		return syscall.ENOSYS
+	}
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(nr, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(flags),
+		_STATX_BTIME, uintptr(unsafe.Pointer(stx)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
 }
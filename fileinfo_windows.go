@@ -2,9 +2,20 @@ package main
 
 import (
 	"os"
+	"time"
 )
 
+const birthTimes = false
+
 func file_ids(info os.FileInfo) (uint64, uint64) {
 	// No dev and inode numbers on windows, right?
 	return 0, 0
 }
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func addBirth(info os.FileInfo, path string, f *os.File) os.FileInfo {
+	return info
+}
@@ -1,8 +1,14 @@
 package main
 
 type FileState struct {
-	Source string `json:"source"`
-	Offset int64  `json:"offset"`
-	Inode  uint64 `json:"inode"`
-	Device int32  `json:"device"`
+	Source     string `json:"source"`
+	Offset     int64  `json:"offset"`
+	Inode      uint64 `json:"inode"`
+	Device     int32  `json:"device"`
+	Birth      int64  `json:"birth,omitempty"`      // when the file was created, in ns, with -file-identity inode_birth
+	Generation int64  `json:"generation,omitempty"` // times the file at Source has been rotated
+	Window     int    `json:"window,omitempty"`     // bytes that Checksum covers, up to Checked
+	Checked    int64  `json:"checked,omitempty"`    // where the bytes Checksum covers end; Offset if 0
+	Checksum   string `json:"checksum,omitempty"`   // see windowChecksum
+	Completed  bool   `json:"completed,omitempty"`  // harvested as far as it's meant to be.  See completions.
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// spoolers can be made to send on whatever they're holding at once, rather
+// than waiting for a full spool or the idle timeout, e.g. to get the latest
+// events to Logstash during an incident.  There's nothing for the registrar
+// to flush: it records positions as soon as they're acknowledged.
+//
+// Flushing is requested with SIGUSR2, or with the flush command on the
+// command port.
+var flushState struct {
+	sync.Mutex
+	requested chan struct{} // closed on each request, then replaced
+}
+
+func init() {
+	flushState.requested = make(chan struct{})
+}
+
+// returns a channel that's closed on the next request to flush.  Hold on to
+// it until it's closed, so as not to miss a request made in the meantime.
+func flushRequested() <-chan struct{} {
+	flushState.Lock()
+	defer flushState.Unlock()
+	return flushState.requested
+}
+
+func requestFlush() {
+	flushState.Lock()
+	defer flushState.Unlock()
+	close(flushState.requested)
+	flushState.requested = make(chan struct{})
+	log.Println("flushing spools")
+}
+
+var flushCmd = cmd{
+	name: "flush",
+	run: func(args []string, w io.Writer) {
+		requestFlush()
+		fmt.Fprintln(w, "ok")
+	},
+}
+
+func init() {
+	registerCmd(flushCmd)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpoolFlush(t *testing.T) {
+	input, output := make(chan *FileEvent), make(chan eventPage, 1)
+	go Spool(input, nil, output, 10, time.Hour, "", 0)
+
+	input <- &FileEvent{Text: "one"}
+	requestFlush()
+	select {
+	case page := <-output:
+		if len(page) != 1 || page[0].Text != "one" {
+			t.Fatalf("expected a page of one event, got %v", page)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("spool wasn't flushed")
+	}
+}
+
+// a page is sent early rather than let it grow past max_bytes.
+func TestSpoolMaxBytes(t *testing.T) {
+	input, output := make(chan *FileEvent), make(chan eventPage, 4)
+	event := func(text string) *FileEvent {
+		return &FileEvent{Source: "a.log", Text: text, Fields: map[string]string{}}
+	}
+	max := event("0123456789").frameSize() * 2
+	go Spool(input, nil, output, 10, time.Hour, "", max)
+
+	for _, text := range []string{"0123456789", "0123456789", "0123456789"} {
+		input <- event(text)
+	}
+	select {
+	case page := <-output:
+		if len(page) != 2 {
+			t.Fatalf("expected a page of the 2 events that fit, got %d", len(page))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("page wasn't sent once it was full by size")
+	}
+}
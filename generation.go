@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+)
+
+// the rotation generation of each path: how many times the file at the path
+// has been rotated, i.e. replaced by a new file or truncated.  Generations
+// are recorded in the progress file, so they keep counting across restarts,
+// and can be added to events as a rotation_generation field so that events
+// can be put in order across rotations downstream.
+var generations = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+func generation(path string) int64 {
+	generations.Lock()
+	defer generations.Unlock()
+	return generations.m[path]
+}
+
+// records that the file at path has been rotated, and returns its new
+// generation.
+func nextGeneration(path string) int64 {
+	generations.Lock()
+	defer generations.Unlock()
+	generations.m[path]++
+	return generations.m[path]
+}
+
+// restores a generation recorded in the progress file.
+func seedGeneration(path string, g int64) {
+	generations.Lock()
+	defer generations.Unlock()
+	if g > generations.m[path] {
+		generations.m[path] = g
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// gzipTail decompresses a gzip file that may still be being written to, one
+// member at a time.  Reaching the end of the data in the middle of a member,
+// or between members, is reported as io.EOF, so the harvester waits for more
+// data as it does for an uncompressed file; the next read starts the
+// incomplete member again from its beginning, skipping what has already been
+// returned from it.
+//
+// Offsets in the decompressed data can't be used to seek in the compressed
+// file, so positions in compressed files aren't recorded, and a compressed
+// file is read from the beginning every time it's harvested.
+type gzipTail struct {
+	file      io.ReadSeeker
+	br        *bufio.Reader
+	z         *gzip.Reader
+	reading   bool  // whether z is part way through a member
+	member    int64 // offset in the file of the start of the current member
+	delivered int64 // bytes of the current member already returned
+}
+
+// readSeeker reads from one reader and seeks in another, so that gzipTail can
+// read a file through a wrapper like retryReader.
+type readSeeker struct {
+	io.Reader
+	io.Seeker
+}
+
+func newGzipTail(file io.ReadSeeker) *gzipTail {
+	return &gzipTail{file: file, br: bufio.NewReader(file)}
+}
+
+func (g *gzipTail) Read(p []byte) (int, error) {
+	for {
+		if !g.reading {
+			if err := g.startMember(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := g.z.Read(p)
+		g.delivered += int64(n)
+		switch err {
+		case nil:
+			return n, nil
+		case io.EOF:
+			// the member is complete, and the next one starts right after it.
+			pos, err := g.file.Seek(0, os.SEEK_CUR)
+			if err != nil {
+				return n, err
+			}
+			g.member, g.delivered, g.reading = pos-int64(g.br.Buffered()), 0, false
+			if n > 0 {
+				return n, nil
+			}
+		case io.ErrUnexpectedEOF:
+			// the rest of the member hasn't been written yet.
+			g.reading = false
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		default:
+			return n, err
+		}
+	}
+}
+
+// (re)starts decompressing the current member, skipping the part of it that
+// has already been returned.
+func (g *gzipTail) startMember() error {
+	if _, err := g.file.Seek(g.member, os.SEEK_SET); err != nil {
+		return err
+	}
+	g.br.Reset(g.file)
+	var err error
+	if g.z == nil {
+		g.z, err = gzip.NewReader(g.br)
+	} else {
+		err = g.z.Reset(g.br)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// no more members yet, or only part of a header.
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	g.z.Multistream(false)
+	if _, err := io.CopyN(ioutil.Discard, g.z, g.delivered); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return io.EOF
+		}
+		return err
+	}
+	g.reading = true
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func gzipMember(t *testing.T, text string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// a gzip file is written a member at a time, and read while the second
+// member is only partly written.
+func TestGzipTail(t *testing.T) {
+	f, err := ioutil.TempFile("", "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	first, second := gzipMember(t, "one\ntwo\n"), gzipMember(t, "three\n")
+	w, err := os.OpenFile(f.Name(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write(first)
+	w.Write(second[:len(second)/2])
+
+	g := newGzipTail(f)
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Fatalf("expected the first member, got %q", b)
+	}
+	if n, err := g.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF in the partial member, got %d, %v", n, err)
+	}
+
+	w.Write(second[len(second)/2:])
+	b, err = ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "three\n" {
+		t.Fatalf("expected the second member once complete, got %q", b)
+	}
+}
+
+// reads of a compressed file are retried as reads of an uncompressed one are.
+func TestGzipTailRetry(t *testing.T) {
+	f := bytes.NewReader(append(gzipMember(t, "one\n"), gzipMember(t, "two\n")...))
+	g := newGzipTail(readSeeker{&retryReader{&eintrReader{r: f}, "test", time.Second}, f})
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatalf("expected interrupted reads to be retried, got %v", err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Fatalf("expected both members, got %q", b)
+	}
+}
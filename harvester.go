@@ -2,13 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -35,16 +40,136 @@ type Harvester struct {
 	Path   string
 	Fields map[string]string
 	join   joinspec
+	conf   *FileConfig
+
+	templates  map[string]fieldTemplate // compiled templated values of Fields
+	context    *lineRing                // recent lines, for context_before
+	skip       int                      // header lines still to be skipped
+	gzip       bool                     // the file is gzip compressed.  See gzipTail.
+	gen        int64                    // rotation generation of the file.  See generations.
+	reopened   bool                     // nothing's been sent since the file was (re)opened
+	resumed    bool                     // nothing's been sent since resuming from the registry
+	danglingCR bool                     // the last line read ended with \r at EOF.  See readLine.
+	goneSince  time.Time                // when the file was first seen deleted and read to the end
+	clock      Clock                    // for telling the time and waiting
+
+	// if set, lines are read from reader instead of from the file at Path.
+	// Such harvesters are not registered, don't watch any directories, and
+	// stop at the reader's EOF.  See newReaderHarvester.
+	reader io.Reader
 
 	moved      bool // this is set when the file has been moved by logrotate
 	file       *os.File
 	fi         os.FileInfo
-	lastRead   time.Time
+	lastRead   time.Time // when a line was last read from the file
 	out        chan *FileEvent
+	errorOuts  []chan *FileEvent // where events matching error_pattern go
+	batch      []*FileEvent      // events yet to be sent, with emit_batch_size
 	lastLine   []byte
 	lastOffset int64
+	lastCheck  time.Time // last time a copytruncate harvester checked for truncation
+	lastShrink shrink    // the last time the file was seen to have shrunk
+
+	readLag     *expvar.Int // bytes between our offset and the end of the file
+	readLagKey  string      // the key readLag is published under
+	lastLagTime time.Time   // last time readLag was updated
 
 	nextPath string
+
+	// closed the first time the harvester reaches the end of its file, or
+	// stops.  May be nil.
+	drained chan struct{}
+
+	// closed when the harvester unregisters, by which time handoff is where
+	// another harvester of the same file should carry on from.  See claim.
+	done    chan struct{}
+	handoff int64
+
+	// set if the harvester stopped because its file went quiet, in which
+	// case it's started again if the file grows.  See hregistry.grown.
+	timedOut bool
+
+	// set, atomically, when the prospector finds the file hasn't been
+	// modified within active_window.  The harvester stops once it has read
+	// to the end, as though it had timed out.
+	inactive int32
+
+	// offsets asked for by the reset command.  See resetCmd.
+	seekTo chan int64
+
+	// how fast the file's being read, with adaptive_read_ahead.  See
+	// adaptReadAhead.
+	readRate readRate
+
+	// set when a line isn't valid UTF-8 and encoding_error is fail.  The
+	// harvester stops before reading any further.
+	encodingErr error
+}
+
+// newHarvester creates a harvester for the file at path, using the settings
+// of the prospector configuration conf.
+func newHarvester(path string, conf *FileConfig, out chan *FileEvent) *Harvester {
+	h := &Harvester{
+		Path:   path,
+		join:   conf.Join,
+		conf:   conf,
+		out:    out,
+		clock:  defaultClock,
+		seekTo: make(chan int64, 1),
+	}
+	h.gzip = conf.Gzip && strings.HasSuffix(path, ".gz")
+	h.gen = generation(path)
+	fieldsLock.RLock()
+	h.setFields(conf.Fields)
+	fieldsLock.RUnlock()
+	if conf.ErrorPattern != nil && conf.ContextBefore > 0 {
+		h.context = newLineRing(conf.ContextBefore)
+	}
+	if conf.errorOut != nil {
+		h.errorOuts = []chan *FileEvent{conf.errorOut}
+		if !conf.ErrorDestOnly {
+			h.errorOuts = append(h.errorOuts, out)
+		}
+	}
+	return h
+}
+
+// sets the harvester's fields to the prospector fields given, plus any path
+// fields, and compiles the templates among them.  The caller must hold
+// fieldsLock.
+func (h *Harvester) setFields(fields map[string]string) {
+	h.Fields = fields
+	if len(h.conf.PathFields) > 0 {
+		h.Fields = make(map[string]string, len(fields)+len(h.conf.PathFields))
+		for k, v := range fields {
+			h.Fields[k] = v
+		}
+		for i, name := range h.conf.PathFields {
+			if v, ok := pathSegment(h.Path, i); ok {
+				h.Fields[name] = v
+			} else {
+				log.Printf("ERROR path %s has no segment %d for path field %s", h.Path, i, name)
+			}
+		}
+	}
+	var errs []error
+	h.templates, errs = compileFieldTemplates(fields)
+	for _, err := range errs {
+		log.Printf("ERROR bad field template for %s: %v", h.Path, err)
+	}
+}
+
+// newReaderHarvester creates a harvester that reads lines from r instead of
+// from a file, and emits them on out.  It never touches the registry or the
+// directory watcher, which makes it suitable for tests and benchmarks.  name
+// is used as the source of the emitted events.  conf may be nil.
+func newReaderHarvester(name string, r io.Reader, conf *FileConfig, out chan *FileEvent) *Harvester {
+	if conf == nil {
+		conf = &FileConfig{}
+	}
+	h := newHarvester(name, conf, out)
+	h.reader = r
+	return h
 }
 
 func (h *Harvester) MarshalJSON() ([]byte, error) {
@@ -57,74 +182,430 @@ func (h *Harvester) MarshalJSON() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	fieldsLock.RLock()
+	fields := h.Fields
+	fieldsLock.RUnlock()
 	v := t{
 		Path:   h.Path,
 		Id:     id,
-		Fields: h.Fields,
+		Fields: fields,
 	}
 	return json.Marshal(v)
 }
 
+// how long a harvester at the end of its file waits before looking for more
+// data.  Set by -eof-poll.
+var eofPoll = time.Second
+
 // readlines reads lines from the harvester's existing file handle.  readlines
-// does not open or seek a file on its own.
+// does not open a file on its own, and only seeks to take over from another
+// harvester.  See claim.
 func (h *Harvester) readlines(timeout time.Duration) {
-	if err := registry.register(h); err != nil {
-		log.Printf("readlines unable to register: %v", err)
-		return
+	defer h.sendBatch()
+	var r *bufio.Reader
+	if h.reader != nil {
+		r = bufio.NewReader(&retryReader{h.reader, h.Path, h.conf.readRetry()})
+	} else {
+		if err := h.claim(); err != nil {
+			log.Printf("readlines unable to register: %v", err)
+			return
+		}
+		r = bufio.NewReader(h.fileReader())
 	}
-	defer registry.unregister(h)
-
-	r := bufio.NewReader(h.file)
 
 	offset, err := h.fileOffset()
 	if err != nil {
 		log.Printf("unable to read file offset in readlines: %v", err)
+		if h.reader == nil {
+			registry.unregister(h)
+		}
 		return
 	}
+	if h.reader == nil {
+		defer func() {
+			h.handoff = offset
+			if len(h.lastLine) > 0 {
+				// the joined event being held was never sent.
+				h.handoff = h.lastOffset
+			}
+			registry.unregister(h)
+		}()
+		defer h.removeReadLag()
+	}
+	if offset == 0 {
+		h.skip = h.conf.SkipLines
+	}
+	h.reopened = true
+
+	// how long we've been waiting at EOF for more data.  This is counted up
+	// from the time spent sleeping rather than worked out from clock
+	// readings, so a clock that's stepped, or a VM that's paused and resumed,
+	// can't make the harvester time out early.
+	var idle time.Duration
 
 	for {
-		h.lastRead = time.Now()
-		line, err := r.ReadBytes('\n')
+		if limitReached() {
+			log.Printf("harvester for %s stopping: shipping limit reached", h.Path)
+			return
+		}
+		waitWhilePaused()
+		if h.encodingErr != nil {
+			log.Printf("ERROR harvester for file %s stopping: %v", h.Path, h.encodingErr)
+			return
+		}
+		if h.reader == nil && h.complete(offset, false) {
+			h.markCompleted(offset)
+			return
+		}
+		if h.reader == nil {
+			select {
+			case to := <-h.seekTo:
+				if err := h.seek(to, r); errors.Is(err, errUnacknowledged) {
+					// nothing's been moved yet.
+					log.Printf("ERROR harvester for file %s not reset to %d: %v", h.Path, to, err)
+					continue
+				} else if err != nil {
+					log.Printf("harvester for file %s stopping: unable to reset: %v", h.Path, err)
+					return
+				}
+				offset = to
+				idle = 0
+				continue
+			default:
+			}
+		}
+		if h.reader == nil && h.conf.MinAgeMs > 0 && r.Buffered() == 0 {
+			h.waitForQuiet(time.Duration(h.conf.MinAgeMs) * time.Millisecond)
+		}
+		if offset == 0 {
+			offset = skipBOM(r)
+		}
+		line, err := h.readLine(r)
+		if len(line) > 0 {
+			h.lastRead = h.clock.Now()
+			idle = 0
+		}
 		switch err {
 		case io.EOF:
+			if h.reader != nil {
+				// an injected reader won't grow, so we're done.
+				if len(line) > 0 {
+					h.emit(line, offset)
+				}
+				h.flush()
+				return
+			}
 			if len(line) > 0 {
+				// the next read hits EOF with nothing, and waits for more
+				// data there, so there's no need to wait here too.
 				log.Printf("harvester hit EOF in %s with line", h.Path)
 				h.emit(line, offset)
-				time.Sleep(1 * time.Second)
 				break
 			}
+			h.sendBatch()
+			h.markDrained()
+			h.updateReadLag(offset)
 			if rewound, err := h.autoRewind(offset, line); err != nil {
-				log.Printf("harvester for file %s stopping: %v", h.Path, err)
-				return
+				if !h.recoverUnavailable(err, offset, r) {
+					log.Printf("harvester for file %s stopping: %v", h.Path, err)
+					return
+				}
 			} else if rewound {
 				offset = 0
+				h.skip = h.conf.SkipLines
+				h.reopened = true
 			}
-			if time.Since(h.lastRead) > timeout {
+			if idle > timeout {
 				log.Printf("harvester timed out: %s", h.Path)
+				h.timedOut = true
+				return
+			}
+			if h.complete(offset, true) {
+				h.markCompleted(offset)
 				return
 			}
-			time.Sleep(1 * time.Second)
+			if atomic.LoadInt32(&h.inactive) != 0 {
+				log.Printf("harvester closing inactive file: %s", h.Path)
+				h.timedOut = true
+				return
+			}
+			h.clock.Sleep(eofPoll)
+			idle += eofPoll
 		case nil:
+			if h.skip > 0 {
+				// part of the file's header.
+				h.skip--
+				break
+			}
 			h.emit(line, offset)
+			if h.reader == nil && h.clock.Now().Sub(h.lastLagTime) > time.Second {
+				h.updateReadLag(offset + int64(len(line)))
+			}
+			if h.reader == nil && h.rotation() == rotateCopyTruncate &&
+				h.clock.Now().Sub(h.lastCheck) > time.Second {
+				h.lastCheck = h.clock.Now()
+				if rewound, err := h.autoRewind(offset+int64(len(line)), nil); err != nil {
+					if !h.recoverUnavailable(err, offset+int64(len(line)), r) {
+						log.Printf("harvester for file %s stopping: %v", h.Path, err)
+						return
+					}
+				} else if rewound {
+					r.Reset(h.fileReader())
+					offset = 0
+					h.skip = h.conf.SkipLines
+					h.reopened = true
+					continue
+				}
+			}
 		default:
-			log.Printf("unable to read line in harvester: %v", err)
+			// transient errors have already been retried by retryReader.
+			if h.recoverUnavailable(err, offset, r) {
+				// any partial line is read again from the reopened file.
+				continue
+			}
+			log.Printf("unable to read line in harvester for %s: %v", h.Path, err)
 			return
 		}
+		if h.conf.AdaptiveReadAhead && h.reader == nil && !h.gzip {
+			r = h.adaptReadAhead(r, len(line))
+		}
 		offset += int64(len(line))
 	}
 }
 
+// registers the harvester.  If another harvester already has the same file,
+// e.g. when a rotation was seen twice, waits for it to stop, and then takes
+// over from where it stopped, so that a file is never left unharvested
+// because the harvester that won the race to it went away.
+func (h *Harvester) claim() error {
+	for {
+		err := registry.register(h)
+		if err == nil {
+			return nil
+		}
+		id, idErr := h.fileId()
+		if idErr != nil {
+			return err
+		}
+		holder := registry.byId(id)
+		if holder == nil {
+			// it may have just stopped.
+			return registry.register(h)
+		}
+		log.Printf("%s is already being harvested, waiting to take over", h.Path)
+		<-holder.done
+		if h.gzip {
+			// offsets into the decompressed data can't be seeked to.
+			continue
+		}
+		if _, err := h.file.Seek(holder.handoff, os.SEEK_SET); err != nil {
+			return fmt.Errorf("unable to seek to %d: %v", holder.handoff, err)
+		}
+	}
+}
+
+// the reader readlines reads the harvester's file through.
+func (h *Harvester) fileReader() io.Reader {
+	var r io.Reader = &retryReader{h.file, h.Path, h.conf.readRetry()}
+	if h.gzip {
+		// gzipTail seeks in the file itself, but reads through retryReader.
+		r = newGzipTail(readSeeker{r, h.file})
+	}
+	if h.conf.readLimit != nil {
+		r = &throttledReader{r, h.conf.readLimit}
+	}
+	return r
+}
+
+// waits until the file hasn't been modified for at least age.  Some programs
+// write a line and then rewrite it in place; waiting for the file to settle
+// before reading from it avoids shipping the first version.
+func (h *Harvester) waitForQuiet(age time.Duration) {
+	for {
+		info, err := h.file.Stat()
+		if err != nil {
+			return
+		}
+		quiet := h.clock.Now().Sub(info.ModTime())
+		if quiet >= age {
+			return
+		}
+		h.clock.Sleep(age - quiet)
+	}
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// discards a UTF-8 byte order mark at the start of a file, which some editors
+// write and which would otherwise end up in the first event.  Returns the
+// number of bytes discarded.
+func skipBOM(r *bufio.Reader) int64 {
+	if b, err := r.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		r.Discard(len(utf8BOM))
+		return int64(len(utf8BOM))
+	}
+	return 0
+}
+
+// reads a line, up to and including its newline.  With mixed_line_endings, a
+// lone \r ends a line too, as well as \n and \r\n.
+func (h *Harvester) readLine(r *bufio.Reader) ([]byte, error) {
+	if h.conf == nil || !h.conf.MixedLineEndings {
+		return r.ReadBytes('\n')
+	}
+	var line []byte
+	if h.danglingCR {
+		// the last line ended with a \r at the end of the file.  If the \n
+		// of a \r\n has been written since, it belongs to that line, but
+		// that's gone, so it leads this one instead, where it's trimmed.
+		next, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		h.danglingCR = false
+		if next[0] == '\n' {
+			r.Discard(1)
+			line = append(line, '\n')
+		}
+	}
+	for {
+		if r.Buffered() == 0 {
+			if _, err := r.Peek(1); err != nil {
+				return line, err
+			}
+		}
+		buf, _ := r.Peek(r.Buffered())
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			line = append(line, buf...)
+			r.Discard(len(buf))
+			continue
+		}
+		line = append(line, buf[:i+1]...)
+		r.Discard(i + 1)
+		if line[len(line)-1] == '\r' {
+			next, err := r.Peek(1)
+			if err == nil && next[0] == '\n' {
+				r.Discard(1)
+				line = append(line, '\n')
+			} else if err == io.EOF {
+				h.danglingCR = true
+			}
+		}
+		return line, nil
+	}
+}
+
 // the event method takes a line of text found at a byte offset in the
 // harvester's current file and wraps it in a *FileEvent object, adding some
-// file-level context to the FileEvent.
-func (h *Harvester) event(text string, offset int64) *FileEvent {
+// file-level context to the FileEvent.  extra holds fields that came with the
+// text, like a syslog message's facility, and is added before the fields are
+// processed, so templates and processors see it.
+func (h *Harvester) event(text string, offset int64, extra map[string]string) *FileEvent {
+	// the fields can be reloaded at any time, but are replaced rather than
+	// modified, so a snapshot of them stays good.
+	fieldsLock.RLock()
+	fields, templates := h.Fields, h.templates
+	fieldsLock.RUnlock()
+
 	e := &FileEvent{
 		Source:   h.Path,
 		Offset:   offset,
 		Text:     strings.TrimSpace(text),
-		Fields:   h.Fields,
+		Fields:   mergeFields(fields),
 		Rotated:  h.moved,
 		fileinfo: h.fi,
+		length:   int64(len(text)),
+		readAt:   h.clock.Now(),
+	}
+	for k, v := range extra {
+		e.Fields[k] = v
+	}
+	if h.conf != nil {
+		e.textKey = h.conf.MessageKey
+	}
+	if h.conf != nil && h.conf.LineHash != "" {
+		// of the line as read, before it's decoded or otherwise changed.
+		h.hashLine(e)
+	}
+	if h.conf != nil && h.conf.FieldsFromSidecar != "" {
+		// the config's fields take precedence.
+		for k, v := range sidecarFields(h.Path, h.conf.FieldsFromSidecar, e.readAt) {
+			if _, ok := fields[k]; !ok {
+				e.Fields[k] = v
+			}
+		}
+	}
+	if h.gzip {
+		// offsets in the decompressed data can't be resumed from.
+		e.fileinfo = nil
+	}
+	if h.conf != nil && h.conf.ReadLagField && h.file != nil {
+		lag := h.updateReadLag(offset + int64(len(text)))
+		e.Fields["read_lag_bytes"] = strconv.FormatInt(lag, 10)
+	}
+	if h.conf != nil && h.conf.DocumentType != "" {
+		e.Fields[h.conf.typeField()] = h.conf.DocumentType
+	}
+	for k, t := range templates {
+		e.Fields[k] = t.render(e)
+	}
+	if h.conf != nil && h.conf.Container != nil {
+		text, err := h.conf.Container.unwrap(e.Text, e.Fields)
+		if err != nil {
+			e.Fields["container_error"] = err.Error()
+		}
+		e.Text = text
+	}
+	if h.conf != nil && h.conf.Codec != "" && h.conf.Codec != codecPlain {
+		decoded := make(map[string]string)
+		if err := h.conf.Codec.decode(e.Text, decoded); err != nil {
+			e.Fields["codec_error"] = err.Error()
+		} else {
+			for k, v := range decoded {
+				if h.conf.keepDecoded(k) {
+					e.Fields[k] = v
+				}
+			}
+			if h.conf.CodecDropLine {
+				e.Text = ""
+			}
+		}
+	}
+	if h.conf != nil && h.conf.TimestampField != "" {
+		h.promoteTimestamp(e)
+	}
+	if h.context != nil {
+		if !h.context.empty() && h.conf.ErrorPattern.MatchString(e.Text) {
+			e.Fields["context_before"] = h.context.String()
+		}
+		h.context.push(e.Text)
+	}
+	if h.conf != nil {
+		for i := range h.conf.Processors {
+			h.conf.Processors[i].apply(e)
+		}
+	}
+	if h.conf != nil {
+		h.fixEncoding(e)
+	}
+	if h.reopened {
+		if h.conf != nil && h.conf.ReopenField {
+			e.Fields["reopen"] = "true"
+		}
+		h.reopened = false
+	}
+	if h.resumed {
+		if h.conf != nil && h.conf.ResumeField {
+			e.Fields["resumed"] = "true"
+		}
+		h.resumed = false
+	}
+	if h.conf != nil && h.conf.OwnerFields && h.fi != nil {
+		ownerFields(h.fi, e.Fields)
+	}
+	if h.conf != nil && h.conf.RotationGeneration {
+		e.Fields["rotation_generation"] = strconv.FormatInt(h.gen, 10)
 	}
 	if h.moved {
 		e.Fields["rotated"] = "true"
@@ -134,22 +615,100 @@ func (h *Harvester) event(text string, offset int64) *FileEvent {
 	return e
 }
 
+// sends an event to the spooler, unless doing so would exceed the shipping
+// limits.
+func (h *Harvester) send(e *FileEvent) {
+	isError := h.errorOuts != nil && h.conf.ErrorPattern.MatchString(e.Text)
+	copies := 1
+	if isError {
+		copies = len(h.errorOuts)
+	}
+	for _, part := range splitEvent(e, h.conf.MaxEventBytes) {
+		if !limitReserve(copies, len(part.Text)) {
+			return
+		}
+		if max := h.conf.CompressTextOver; max > 0 && len(part.Text) > max {
+			if text, ok := compressText(part.Text, h.conf.CompressTextRatio); ok {
+				part.Text = text
+				part.Fields["text_encoding"] = "gzip"
+			}
+		}
+		if len(h.batch) > 0 && (isError || inflightFull()) {
+			// sent first to keep events in order, and so the ones waiting
+			// in the batch can be acknowledged, making room.
+			h.sendBatch()
+		}
+		inflightWait()
+		if !isError && h.conf.batchOut != nil {
+			acks.sent(part)
+			inflightSent(part)
+			h.batch = append(h.batch, part)
+			if len(h.batch) >= h.conf.EmitBatchSize {
+				h.sendBatch()
+			}
+			continue
+		}
+		if !isError {
+			acks.sent(part)
+			inflightSent(part)
+			h.out <- part
+			continue
+		}
+		// each copy is acknowledged separately, and the file's position
+		// can't move past the event until every copy has been.
+		for _, out := range h.errorOuts {
+			acks.sent(part)
+			inflightSent(part)
+			out <- part
+		}
+	}
+}
+
+// sends the events held back by emit_batch_size to the spooler.
+func (h *Harvester) sendBatch() {
+	if len(h.batch) == 0 {
+		return
+	}
+	h.conf.batchOut <- h.batch
+	h.batch = nil
+}
+
+// sends the event for text, unless it's too old to be worth shipping, or the
+// transform plugin drops it.
+func (h *Harvester) ship(text string, offset int64, extra map[string]string) {
+	if h.tooOld(text) {
+		return
+	}
+	e := h.event(text, offset, extra)
+	if h.conf != nil && !h.checkEncoding(e) {
+		return
+	}
+	if h.conf != nil && h.conf.TransformPlugin != nil && !h.conf.TransformPlugin.apply(e) {
+		return
+	}
+	h.send(e)
+}
+
 func (h *Harvester) emit(line []byte, offset int64) {
+	if h.conf != nil && h.conf.RecordSeparator != nil {
+		h.emitRecord(line, offset)
+		return
+	}
 	if h.join == nil {
-		h.out <- h.event(string(line[:]), offset)
+		h.ship(string(line[:]), offset, nil)
 		return
 	}
 	for _, v := range h.join {
 		if v.with == "previous" {
 			if v.match != nil {
 				if v.match.Match(line) {
-					h.lastLine = append(h.lastLine, line...)
+					h.joinPrevious(line, offset)
 					return
 				}
 			}
 			if v.not != nil {
 				if !v.not.Match(line) {
-					h.lastLine = append(h.lastLine, line...)
+					h.joinPrevious(line, offset)
 					return
 				}
 			}
@@ -157,13 +716,81 @@ func (h *Harvester) emit(line []byte, offset int64) {
 	}
 
 	if len(h.lastLine) > 0 {
-		h.out <- h.event(string(h.lastLine[:]), h.lastOffset)
+		h.ship(string(h.lastLine[:]), h.lastOffset, nil)
 	}
 	h.lastLine = line
 	h.lastOffset = offset
 }
 
+// stats the file to find how far behind its end we are, and records it in the
+// read_lag_bytes stat.
+func (h *Harvester) updateReadLag(offset int64) int64 {
+	if h.gzip {
+		return 0
+	}
+	h.lastLagTime = h.clock.Now()
+	info, err := h.file.Stat()
+	if err != nil {
+		return 0
+	}
+	lag := info.Size() - offset
+	if lag < 0 {
+		lag = 0
+	}
+	if h.readLag == nil {
+		h.readLag, h.readLagKey = new(expvar.Int), h.Path
+		readLagStat.Set(h.readLagKey, h.readLag)
+	}
+	h.readLag.Set(lag)
+	return lag
+}
+
+func (h *Harvester) removeReadLag() {
+	if h.readLag != nil {
+		readLagStat.Delete(h.readLagKey)
+		h.readLag = nil
+	}
+}
+
+func (h *Harvester) markDrained() {
+	if h.drained != nil {
+		close(h.drained)
+		h.drained = nil
+	}
+}
+
+// appends line to the event being joined.  If there is no previous line to
+// join to, line starts the event.
+func (h *Harvester) joinPrevious(line []byte, offset int64) {
+	if len(h.lastLine) == 0 {
+		h.lastOffset = offset
+	}
+	h.lastLine = append(h.lastLine, line...)
+}
+
+// holds on to lines until a separator line, and then sends them as one
+// event.  The record after the last separator is only sent once the next
+// separator is read, or at the end of a stream that won't grow.
+func (h *Harvester) emitRecord(line []byte, offset int64) {
+	if h.conf.RecordSeparator.Match(bytes.TrimRight(line, "\r\n")) {
+		h.flush()
+		return
+	}
+	h.joinPrevious(line, offset)
+}
+
+// sends any partially joined event that emit is holding on to.
+func (h *Harvester) flush() {
+	if len(h.lastLine) > 0 {
+		h.ship(string(h.lastLine[:]), h.lastOffset, nil)
+		h.lastLine = nil
+	}
+}
+
 func (h *Harvester) fileOffset() (int64, error) {
+	if h.reader != nil {
+		return 0, nil
+	}
 	return h.file.Seek(0, os.SEEK_CUR)
 }
 
@@ -176,15 +803,40 @@ func (h *Harvester) fileId() (fileId, error) {
 
 func (h *Harvester) Harvest(offset int64, opt int) {
 	defer log.Printf("harvester done reading file %s", h.Path)
+	defer h.markDrained()
+	if h.reader != nil {
+		h.readlines(24 * time.Hour)
+		return
+	}
 	watchDir(filepath.Dir(h.Path))
+	defer unwatchDir(filepath.Dir(h.Path))
 	log.Printf("Starting harvester: %s\n", h.Path)
 
+	if h.conf.WholeFile {
+		h.readWhole()
+		return
+	}
+
 	h.open(offset, opt)
-	defer h.file.Close()
+	// h.file is replaced if the file has to be reopened.
+	defer func() { h.file.Close() }()
+	if h.fi != nil && h.fi.IsDir() {
+		// reads from a directory fail, or worse, so don't try.
+		log.Printf("WARNING not harvesting %s: it's a directory", h.Path)
+		return
+	}
+	defer h.forgetAcks()
 
 	h.readlines(24 * time.Hour)
 }
 
+func (h *Harvester) forgetAcks() {
+	if id, err := h.fileId(); err == nil {
+		info, err := h.file.Stat()
+		acks.forget(id, err == nil && unlinked(info))
+	}
+}
+
 func (h *Harvester) resume(offset int64, line []byte) {
 	defer log.Printf("harvester done reading file %s", h.Path)
 	log.Printf("trying to resume %s at offset %d", h.Path, offset)
@@ -194,7 +846,7 @@ func (h *Harvester) resume(offset int64, line []byte) {
 	}
 
 	h.open(offset, 0)
-	defer h.file.Close()
+	defer func() { h.file.Close() }()
 
 	_, err := h.file.ReadAt(line, offset-int64(len(line)))
 	if err != nil {
@@ -206,55 +858,209 @@ func (h *Harvester) resume(offset int64, line []byte) {
 	}
 }
 
+func (h *Harvester) rotation() rotationMode {
+	if h.conf == nil || h.conf.Rotation == "" {
+		return rotateAuto
+	}
+	return h.conf.Rotation
+}
+
 // checks to see if the file has been truncated, and if so, rewinds the file
 // handle.
 func (h *Harvester) autoRewind(offset int64, line []byte) (bool, error) {
+	if h.gzip {
+		// offset is in the decompressed data, so can't be compared with the
+		// size of the file.
+		offset = 0
+	}
 	s, err := h.status(offset)
+	if s != hf_Gone {
+		h.goneSince = time.Time{}
+	}
 	switch s {
 	case hf_Err:
-		return false, fmt.Errorf("unable to autoRewind: %v", err)
+		return false, fmt.Errorf("unable to autoRewind: %w", err)
 	case hf_Ok:
+		if h.rotation() == rotateCreate || polling() {
+			// without a watcher, this is how renames are noticed.
+			h.checkReplaced()
+		}
 		return false, nil
 	case hf_Trunc:
+		if h.rotation() == rotateCreate {
+			// logged by status.
+			return false, nil
+		}
 		if h.nextPath != "" {
-			newh := Harvester{Path: h.nextPath, Fields: h.Fields, out: h.out}
+			newh := newHarvester(h.nextPath, h.conf, h.out)
 			go newh.resume(offset, line)
 			h.nextPath = ""
 		}
-		return true, h.rewind()
+		// a line being joined was read before the truncation, so goes
+		// with what came before it.
+		h.flush()
+		h.gen = nextGeneration(h.Path)
+		if err := h.rewind(); err != nil {
+			return true, err
+		}
+		if h.conf != nil && h.conf.TruncationEvents {
+			e := h.controlEvent("truncated")
+			e.Fields["old_offset"] = strconv.FormatInt(offset, 10)
+			h.send(e)
+		}
+		return true, nil
 	case hf_Gone:
+		if h.conf != nil && h.conf.GoneGraceMs > 0 {
+			grace := time.Duration(h.conf.GoneGraceMs) * time.Millisecond
+			// late writes to the deleted file may still be on their way.
+			if h.goneSince.IsZero() {
+				h.goneSince = h.clock.Now()
+			}
+			if h.clock.Now().Sub(h.goneSince) < grace {
+				return false, nil
+			}
+		}
 		return false, fmt.Errorf("file is gone: %s", h.Path)
 	default:
 		return false, fmt.Errorf("unknown harvester file status: %v", s)
 	}
 }
 
+// in create rotation mode, a file is rotated by renaming it and creating a new
+// file in its place.  If our path now refers to a different file, the file
+// we're reading has been rotated; a new harvester will be started for the new
+// file by the prospector.
+func (h *Harvester) checkReplaced() {
+	if h.moved || h.fi == nil {
+		return
+	}
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return
+	}
+	if !is_fileinfo_same(h.fi, info) {
+		log.Printf("file %s has been replaced, marking as rotated", h.Path)
+		h.moved = true
+	}
+}
+
+// reports whether the file info is of an open file that's been deleted.
+func unlinked(info os.FileInfo) bool {
+	raw, ok := info.Sys().(*syscall.Stat_t)
+	return ok && raw.Nlink == 0
+}
+
+// an offset, and the smaller size of the file it was read from.
+type shrink struct {
+	offset, size int64
+}
+
 func (h *Harvester) status(offset int64) (hfStatus, error) {
 	info, err := h.file.Stat()
 	if err != nil {
-		return hf_Err, fmt.Errorf("unable to stat file in harvester: %s", err.Error())
-	}
-	if info.Sys() != nil {
-		raw, ok := info.Sys().(*syscall.Stat_t)
-		if ok && raw.Nlink == 0 {
-			if info.Size() > offset {
-				log.Printf("deleted file has more data.  size: %d, our offset: %d", info.Size(), offset)
-				return hf_Ok, nil
-			}
-			return hf_Gone, nil
+		return hf_Err, fmt.Errorf("unable to stat file in harvester: %w", err)
+	}
+	if unlinked(info) {
+		if info.Size() > offset {
+			log.Printf("deleted file has more data.  size: %d, our offset: %d", info.Size(), offset)
+			return hf_Ok, nil
 		}
+		return hf_Gone, nil
 	}
 	if info.Size() < offset {
-		log.Printf("file %s is at offset %d but size is %d", h.Path, offset, info.Size())
+		// in create mode the file isn't rewound, so this is seen again at
+		// every poll until it grows back; it's only logged the first time.
+		if s := (shrink{offset, info.Size()}); s != h.lastShrink {
+			h.lastShrink = s
+			log.Printf("file %s is at offset %d but size is %d", h.Path, offset, info.Size())
+			if h.rotation() == rotateCreate {
+				log.Printf("file %s shrank, but rotation mode is create; not rewinding", h.Path)
+			}
+		}
 		return hf_Trunc, nil
 	}
 	return hf_Ok, nil
 }
 
+// if err means the filesystem holding the harvester's file has gone away,
+// e.g. a network mount has dropped, waits for it to come back and reopens the
+// file at offset, resetting r to read from it.  Returns whether readlines can
+// carry on reading.
+func (h *Harvester) recoverUnavailable(err error, offset int64, r *bufio.Reader) bool {
+	if h.reader != nil || h.Path == "-" || !unavailable(err) {
+		return false
+	}
+	log.Printf("filesystem unavailable for %s, will reopen it when it's back: %v", h.Path, err)
+	if err := h.reopen(offset); err != nil {
+		log.Printf("unable to reopen %s: %v", h.Path, err)
+		return false
+	}
+	r.Reset(h.fileReader())
+	h.reopened = true
+	return true
+}
+
+// closes the harvester's file, and opens its path again once it can, seeking
+// to offset.  Fails if the path now refers to a different file.
+func (h *Harvester) reopen(offset int64) error {
+	h.file.Close()
+	backoff := time.Second
+	for {
+		h.clock.Sleep(backoff)
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+		f, err := os.Open(h.Path)
+		if err != nil {
+			log.Printf("still unable to open %s: %v", h.Path, err)
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			log.Printf("still unable to stat %s: %v", h.Path, err)
+			continue
+		}
+		if h.fi != nil && !is_fileinfo_same(h.fi, info) {
+			f.Close()
+			return fmt.Errorf("%s is a different file than before", h.Path)
+		}
+		if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to seek to %d: %v", offset, err)
+		}
+		log.Printf("reopened %s at offset %d", h.Path, offset)
+		h.file = f
+		return nil
+	}
+}
+
+// an event telling of something that happened to the harvester's file, rather
+// than a line read from it.  It has no text, and no position to record.
+func (h *Harvester) controlEvent(eventType string) *FileEvent {
+	fieldsLock.RLock()
+	fields := h.Fields
+	fieldsLock.RUnlock()
+
+	e := &FileEvent{
+		Source: h.Path,
+		Fields: mergeFields(fields),
+		readAt: h.clock.Now(),
+	}
+	if h.conf != nil {
+		e.textKey = h.conf.MessageKey
+	}
+	e.Fields["event_type"] = eventType
+	return e
+}
+
 func (h *Harvester) rewind() error {
 	_, err := h.file.Seek(0, os.SEEK_SET)
 	if err == nil {
 		log.Printf("rewind %s", h.Path)
+		if id, err := h.fileId(); err == nil {
+			acks.rewound(id)
+		}
 	}
 	return err
 }
@@ -266,24 +1072,33 @@ func (h *Harvester) open(offset int64, opt int) *os.File {
 		return h.file
 	}
 
+	// retry on failure.  Each open starts the backoff afresh.
+	delay := h.conf.OpenRetry.first()
 	for {
 		var err error
 		h.file, err = os.Open(h.Path)
-
-		if err != nil {
-			// retry on failure.
-			log.Printf("Failed opening stupid file %s: %s\n", h.Path, err)
-			time.Sleep(5 * time.Second)
-		} else {
+		if err == nil {
 			break
 		}
+		log.Printf("unable to open %s, retrying in %v: %s\n", h.Path, delay, err)
+		h.clock.Sleep(delay)
+		delay = h.conf.OpenRetry.next(delay)
+	}
+
+	if h.gzip {
+		offset, opt = 0, opt|h_Rewind
+	}
+	if offset == 0 && opt&h_Rewind == 0 && useXattrs() {
+		if stored, ok := xattrOffset(h.file); ok {
+			offset = stored
+		}
 	}
 
 	// TODO(sissel): Only seek if the file is a file, not a pipe or socket.
 	if offset > 0 {
 		h.file.Seek(offset, os.SEEK_SET)
 		log.Printf("reading from %d: %s", offset, h.Path)
-	} else if options.FromBeginning || opt&h_Rewind > 0 {
+	} else if h.conf.fromBeginning() || opt&h_Rewind > 0 {
 		h.file.Seek(0, os.SEEK_SET)
 		log.Printf("reading from beginning: %s", h.Path)
 	} else {
@@ -292,10 +1107,16 @@ func (h *Harvester) open(offset int64, opt int) *os.File {
 	}
 
 	var err error
-	h.fi, err = h.file.Stat()
+	h.fi, err = statOpen(h.file)
 	if err != nil {
 		log.Printf("unable to stat file: %s", err.Error())
 	}
+	if pos, err := h.file.Seek(0, os.SEEK_CUR); err == nil && pos == 0 && h.fi != nil {
+		// whatever's waiting to be acknowledged for this id is from an
+		// earlier read of the file, which is about to be read again, or from
+		// a deleted file whose inode it's been given.
+		acks.reset(filestring(h.fi), 0)
+	}
 
 	return h.file
 }
@@ -0,0 +1,991 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+// runs a reader harvester over input and collects everything it emits.
+func harvestString(conf *FileConfig, input string) []*FileEvent {
+	out := make(chan *FileEvent, 1024)
+	h := newReaderHarvester("test", strings.NewReader(input), conf, out)
+	h.readlines(0)
+	close(out)
+
+	var events []*FileEvent
+	for e := range out {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestReaderHarvester(t *testing.T) {
+	events := harvestString(nil, "one\ntwo\nthree")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	expected := []struct {
+		text   string
+		offset int64
+	}{{"one", 0}, {"two", 4}, {"three", 8}}
+	for i, e := range events {
+		if e.Text != expected[i].text || e.Offset != expected[i].offset {
+			t.Fatalf("event %d: expected %q at %d, got %q at %d",
+				i, expected[i].text, expected[i].offset, e.Text, e.Offset)
+		}
+		if e.Source != "test" {
+			t.Fatalf("event %d: unexpected source %q", i, e.Source)
+		}
+	}
+}
+
+func TestReaderHarvesterJoin(t *testing.T) {
+	var conf FileConfig
+	err := json.Unmarshal([]byte(`{"join": [{"not": "^\\d", "with": "previous"}]}`), &conf)
+	if err != nil {
+		t.Fatalf("bad joinspec: %v", err)
+	}
+
+	events := harvestString(&conf, "1 start\n  more\n  more\n2 next\n")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Text != "1 start\n  more\n  more" {
+		t.Fatalf("unexpected joined text: %q", events[0].Text)
+	}
+	if events[1].Text != "2 next" || events[1].Offset != 22 {
+		t.Fatalf("unexpected last event: %q at %d", events[1].Text, events[1].Offset)
+	}
+}
+
+func TestReaderHarvesterRecordSeparator(t *testing.T) {
+	var conf FileConfig
+	if err := json.Unmarshal([]byte(`{"record_separator": "^-{4,}$"}`), &conf); err != nil {
+		t.Fatalf("bad record_separator: %v", err)
+	}
+
+	events := harvestString(&conf, "a\nb\n----\r\n----\nc\n-- d\n----\ne\n")
+	expected := []struct {
+		text   string
+		offset int64
+	}{
+		{"a\nb", 0},
+		{"c\n-- d", 15},
+		{"e", 27},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range events {
+		if e.Text != expected[i].text || e.Offset != expected[i].offset {
+			t.Fatalf("event %d: expected %q at %d, got %q at %d",
+				i, expected[i].text, expected[i].offset, e.Text, e.Offset)
+		}
+	}
+}
+
+func BenchmarkReaderHarvester(b *testing.B) { benchmarkReaderHarvester(b, nil) }
+
+// the same, with events handed to the spooler in batches.
+func BenchmarkReaderHarvesterEmitBatch(b *testing.B) {
+	benchmarkReaderHarvester(b, &FileConfig{EmitBatchSize: 64})
+}
+
+// reads a busy file's worth of lines through a spooler, which is where
+// handing events over one at a time costs.
+func benchmarkReaderHarvester(b *testing.B, conf *FileConfig) {
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		buf.WriteString("2014-01-01 00:00:00 a log line of a fairly typical length\n")
+	}
+	input := buf.Bytes()
+	out, pages := make(chan *FileEvent, 16), make(chan eventPage, 16)
+	if conf != nil {
+		conf.batchOut = make(chan []*FileEvent, 16)
+		go Spool(out, conf.batchOut, pages, 1024, time.Hour, "", 0)
+	} else {
+		go Spool(out, nil, pages, 1024, time.Hour, "", 0)
+	}
+	go func() {
+		for _ = range pages {
+		}
+	}()
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := newReaderHarvester("bench", bytes.NewReader(input), conf, out)
+		h.readlines(0)
+	}
+}
+
+func TestReaderHarvesterEmitBatch(t *testing.T) {
+	conf := &FileConfig{EmitBatchSize: 2, batchOut: make(chan []*FileEvent, 8)}
+	out := make(chan *FileEvent, 8)
+	h := newReaderHarvester("test", strings.NewReader("one\ntwo\nthree\n"), conf, out)
+	h.readlines(0)
+	close(conf.batchOut)
+
+	var sizes []string
+	for batch := range conf.batchOut {
+		sizes = append(sizes, strconv.Itoa(len(batch)))
+	}
+	if got := strings.Join(sizes, ","); got != "2,1" {
+		t.Fatalf("expected a full batch and the rest at the end, got batches of %s", got)
+	}
+	if len(out) != 0 {
+		t.Fatalf("expected nothing sent one at a time, got %d events", len(out))
+	}
+}
+
+func TestReaderHarvesterBOM(t *testing.T) {
+	events := harvestString(nil, "\xEF\xBB\xBF{\"a\": 1}\n\xEF\xBB\xBFnot at the start\n")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Text != `{"a": 1}` || events[0].Offset != 3 {
+		t.Fatalf("expected BOM to be stripped, got %q at %d", events[0].Text, events[0].Offset)
+	}
+	if events[1].Text != "\xEF\xBB\xBFnot at the start" {
+		t.Fatalf("expected only a leading BOM to be stripped, got %q", events[1].Text)
+	}
+}
+
+// feeds arbitrary input through a reader harvester, read in differently sized
+// chunks, and checks that every byte of it ends up in exactly one event.
+func FuzzReadlines(f *testing.F) {
+	f.Add([]byte("one\ntwo\nthree"), uint8(0), false)
+	f.Add([]byte("\xEF\xBB\xBFbom\n\n\r\n"), uint8(1), true)
+	f.Add([]byte("1 start\n  more\n2 next\n  more"), uint8(2), true)
+	f.Add([]byte{}, uint8(0), false)
+
+	var join FileConfig
+	if err := json.Unmarshal([]byte(`{"join": [{"match": "^\\s", "with": "previous"}]}`), &join); err != nil {
+		f.Fatalf("bad joinspec: %v", err)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte, chunking uint8, joined bool) {
+		var r io.Reader = bytes.NewReader(input)
+		switch chunking % 3 {
+		case 1:
+			r = iotest.OneByteReader(r)
+		case 2:
+			r = iotest.HalfReader(r)
+		}
+		conf := &FileConfig{}
+		if joined {
+			conf = &join
+		}
+
+		out := make(chan *FileEvent, len(input)+1)
+		h := newReaderHarvester("fuzz", r, conf, out)
+		h.readlines(0)
+		close(out)
+
+		var next int64
+		if bytes.HasPrefix(input, utf8BOM) {
+			next = int64(len(utf8BOM))
+		}
+		for e := range out {
+			if e.Offset < 0 {
+				t.Fatalf("negative offset %d", e.Offset)
+			}
+			if e.Offset != next {
+				t.Fatalf("event at offset %d, expected %d: bytes skipped or repeated", e.Offset, next)
+			}
+			if e.length <= 0 {
+				t.Fatalf("event at offset %d has length %d", e.Offset, e.length)
+			}
+			next = e.Offset + e.length
+		}
+		if next != int64(len(input)) {
+			t.Fatalf("events account for %d of %d bytes", next, len(input))
+		}
+	})
+}
+
+// a fake clock that runs the next step of a script whenever the harvester
+// waits for more data.
+type scriptClock struct {
+	*fakeClock
+	step func()
+}
+
+func (c *scriptClock) Sleep(d time.Duration) {
+	c.fakeClock.Sleep(d)
+	c.step()
+}
+
+// appends to and truncates a file between reads, as the fuzz input says, and
+// checks that every byte written ends up in exactly one event: after each
+// truncation, from the beginning of the new contents.  Each byte of script
+// appends up to 7 of the bytes after it, or, if a multiple of 4, truncates
+// the file.
+func FuzzHarvesterFile(f *testing.F) {
+	f.Add([]byte("\x04one\n\x00\x04two\n"))
+	f.Add([]byte("\x02tw\x03o\nt\x00\x01\n"))
+	f.Add([]byte("\x07a\r\nb\nc\n\x00\x00\x03\n\n\n"))
+
+	f.Fuzz(func(t *testing.T, script []byte) {
+		defer testAcks()()
+		out := make(chan *FileEvent, len(script)+1)
+		h := fileHarvester(t, "", out)
+		defer os.Remove(h.Path)
+		defer h.file.Close()
+		defer endHarvesters()
+		w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		// the sizes of the file's contents, between truncations.
+		var sizes []int64
+		var size int64
+		h.clock = &scriptClock{newFakeClock(), func() {
+			if len(script) == 0 {
+				atomic.StoreInt32(&h.inactive, 1)
+				return
+			}
+			op := script[0]
+			script = script[1:]
+			if op%4 == 0 {
+				if size > 0 {
+					sizes = append(sizes, size)
+				}
+				if err := os.Truncate(h.Path, 0); err != nil {
+					t.Fatal(err)
+				}
+				size = 0
+				return
+			}
+			n := int(op % 8)
+			if n > len(script) {
+				n = len(script)
+			}
+			// a BOM is stripped at the start of the file, and isn't
+			// what's being tested.
+			data := bytes.Replace(script[:n], []byte{0xEF}, []byte{'x'}, -1)
+			script = script[n:]
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			size += int64(n)
+		}}
+		h.readlines(24 * time.Hour)
+		close(out)
+		if size > 0 {
+			sizes = append(sizes, size)
+		}
+
+		var next int64
+		contents := 0
+		for e := range out {
+			if e.Offset != next {
+				if e.Offset != 0 || contents >= len(sizes) || next != sizes[contents] {
+					t.Fatalf("event at offset %d, expected %d: bytes skipped or repeated", e.Offset, next)
+				}
+				// the file was truncated.
+				contents++
+			}
+			if e.length <= 0 {
+				t.Fatalf("event at offset %d has length %d", e.Offset, e.length)
+			}
+			next = e.Offset + e.length
+		}
+		if len(sizes) == 0 {
+			if next != 0 {
+				t.Fatalf("events account for %d bytes of an empty file", next)
+			}
+		} else if contents != len(sizes)-1 || next != sizes[contents] {
+			t.Fatalf("events account for %d of %d bytes in the contents after truncation %d", next, sizes[contents], contents)
+		}
+	})
+}
+
+func TestReaderHarvesterContext(t *testing.T) {
+	var conf FileConfig
+	if err := json.Unmarshal([]byte(`{"error_pattern": "ERROR", "context_before": 2}`), &conf); err != nil {
+		t.Fatalf("bad config: %v", err)
+	}
+	events := harvestString(&conf, "one\ntwo\nthree\nERROR four\nfive\n")
+	if _, ok := events[2].Fields["context_before"]; ok {
+		t.Fatalf("unexpected context on a line that didn't match")
+	}
+	if v := events[3].Fields["context_before"]; v != "two\nthree" {
+		t.Fatalf("unexpected context: %q", v)
+	}
+}
+
+func TestReaderHarvesterSplit(t *testing.T) {
+	line := strings.Repeat("0123456789é", 300000) // 3.6MB
+	events := harvestString(&FileConfig{MaxEventBytes: 1 << 20}, line+"\nnext\n")
+	if len(events) != 5 {
+		t.Fatalf("expected 4 parts and 1 more event, got %d events", len(events))
+	}
+
+	var buf bytes.Buffer
+	for i, e := range events[:4] {
+		if e.Fields["split_id"] != events[0].Fields["split_id"] {
+			t.Fatalf("part %d has a different split_id", i)
+		}
+		if e.Fields["split_index"] != strconv.Itoa(i) || e.Fields["split_total"] != "4" {
+			t.Fatalf("part %d: unexpected split fields %v", i, e.Fields)
+		}
+		if len(e.Text) > 1<<20 || !utf8.ValidString(e.Text) {
+			t.Fatalf("part %d: bad text", i)
+		}
+		buf.WriteString(e.Text)
+	}
+	if buf.String() != line {
+		t.Fatalf("reassembled parts don't match the original line")
+	}
+	if events[3].Offset+events[3].length != events[4].Offset {
+		t.Fatalf("last part should account for the whole line")
+	}
+	if _, ok := events[4].Fields["split_id"]; ok {
+		t.Fatalf("unexpected split fields on a short event")
+	}
+}
+
+func TestReaderHarvesterSkipLines(t *testing.T) {
+	events := harvestString(&FileConfig{SkipLines: 2}, "# header\n# more header\ndata\n")
+	if len(events) != 1 || events[0].Text != "data" || events[0].Offset != 23 {
+		t.Fatalf("expected only the data line, got %v", events)
+	}
+}
+
+// creates the registry harvesters register with, if a test hasn't already.
+func testRegistry() {
+	if registry == nil {
+		registry = &hregistry{
+			RunningIds:   make(map[fileId]*Harvester),
+			RunningPaths: make(map[string]*Harvester),
+			paths:        make(map[string]bool),
+		}
+	}
+}
+
+// creates a harvester for a temporary file holding input, ready to have
+// readlines called on it.
+func fileHarvester(t *testing.T, input string, out chan *FileEvent) *Harvester {
+	testRegistry()
+	f, err := ioutil.TempFile("", "harvester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarvester(f.Name(), &FileConfig{}, out)
+	h.file, h.fi = f, info
+	return h
+}
+
+// the wall clock jumps forward two days while the harvester is waiting for
+// data.  It must keep waiting for the full timeout, and still time out once
+// that's really passed.
+func TestHarvesterClockJump(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	h.lastRead = time.Now().Add(-48 * time.Hour).Round(0)
+
+	done := startReadlines(h, 200*time.Millisecond)
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+
+	time.Sleep(5 * eofPoll)
+	select {
+	case <-done:
+		t.Fatal("harvester timed out early")
+	default:
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("two\n")
+	w.Close()
+	select {
+	case e := <-out:
+		if e.Text != "two" {
+			t.Fatalf("expected two, got %q", e.Text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("harvester didn't pick up appended line")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("harvester never timed out")
+	}
+}
+
+func TestReaderHarvesterErrorDest(t *testing.T) {
+	var conf FileConfig
+	if err := json.Unmarshal([]byte(`{"error_pattern": "ERROR", "error_dest_only": true}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+	conf.errorOut = make(chan *FileEvent, 16)
+	events := harvestString(&conf, "ok\nERROR bad\nok again\n")
+	close(conf.errorOut)
+
+	if len(events) != 2 || events[0].Text != "ok" || events[1].Text != "ok again" {
+		t.Fatalf("expected only the normal events on the normal path, got %v", events)
+	}
+	var errors []*FileEvent
+	for e := range conf.errorOut {
+		errors = append(errors, e)
+	}
+	if len(errors) != 1 || errors[0].Text != "ERROR bad" {
+		t.Fatalf("expected the error event on the error path, got %v", errors)
+	}
+}
+
+// fails every other read with EINTR, as reads on some filesystems do when
+// interrupted by a signal.
+type eintrReader struct {
+	r    io.Reader
+	fail bool
+}
+
+func (e *eintrReader) Read(p []byte) (int, error) {
+	if e.fail = !e.fail; e.fail {
+		return 0, &os.PathError{Op: "read", Path: "test", Err: syscall.EINTR}
+	}
+	return e.r.Read(p)
+}
+
+func TestReaderHarvesterEINTR(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	h := newReaderHarvester("test", &eintrReader{r: iotest.OneByteReader(strings.NewReader("one\ntwo\n"))}, nil, out)
+	h.readlines(0)
+	close(out)
+
+	var texts []string
+	for e := range out {
+		texts = append(texts, e.Text)
+	}
+	if strings.Join(texts, ",") != "one,two" {
+		t.Fatalf("expected the harvester to read through interrupted reads, got %v", texts)
+	}
+}
+
+// fails every read with EAGAIN.
+type eagainReader struct{}
+
+func (eagainReader) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: "test", Err: syscall.EAGAIN}
+}
+
+// a read that keeps failing with a transient error is given up on once
+// read_retry_ms has passed, rather than retried forever.
+func TestReaderHarvesterReadRetryLimit(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	r := io.MultiReader(strings.NewReader("one\n"), eagainReader{})
+	h := newReaderHarvester("test", r, &FileConfig{ReadRetryMs: 50}, out)
+	done := make(chan struct{})
+	go func() {
+		h.readlines(0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("harvester kept retrying past read_retry_ms")
+	}
+	close(out)
+	if e := <-out; e == nil || e.Text != "one" {
+		t.Fatalf("expected what was read before the errors to be shipped, got %v", e)
+	}
+}
+
+func TestReaderHarvesterFallbackEncoding(t *testing.T) {
+	events := harvestString(&FileConfig{FallbackEncoding: "base64"}, "ok\n\xff\xfe\n")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Text != "ok" || events[0].Fields["encoding"] != "" {
+		t.Fatalf("valid line was encoded: %q %v", events[0].Text, events[0].Fields)
+	}
+	if events[1].Text != "//4=" || events[1].Fields["encoding"] != "base64" {
+		t.Fatalf("invalid line wasn't base64 encoded: %q %v", events[1].Text, events[1].Fields)
+	}
+}
+
+func TestReaderHarvesterEncodingError(t *testing.T) {
+	input := "ok\nbad \xff\xfe\nafter\n"
+	for policy, expected := range map[string]string{
+		"":              "ok,bad \ufffd\ufffd,after",
+		encodingReplace: "ok,bad \ufffd\ufffd,after",
+		encodingDrop:    "ok,after",
+		encodingBase64:  "ok,YmFkIP/+,after",
+		encodingFail:    "ok",
+	} {
+		var texts []string
+		for _, e := range harvestString(&FileConfig{EncodingError: policy}, input) {
+			texts = append(texts, e.Text)
+		}
+		if strings.Join(texts, ",") != expected {
+			t.Errorf("encoding_error %q: expected %q, got %q", policy, expected, texts)
+		}
+	}
+}
+
+// harvesters tests have started with startReadlines.  endHarvesters waits
+// for them along with those the prospector has started.
+var testHarvesting sync.WaitGroup
+
+// runs readlines in the background.  done is closed once it returns.
+func startReadlines(h *Harvester, timeout time.Duration) (done chan struct{}) {
+	done = make(chan struct{})
+	testHarvesting.Add(1)
+	go func() {
+		defer testHarvesting.Done()
+		defer close(done)
+		h.readlines(timeout)
+	}()
+	return done
+}
+
+// stops the harvesters a test has started, itself or through the prospector,
+// once they've read to the end of their files, and waits for them to return,
+// so that none is still polling once eofPoll is restored.  It then clears
+// the registry and the acknowledgements they leave behind: a later test's
+// temporary file may reuse an inode, and claim would wait for a harvester
+// still registered for it.  Deferred by every test that runs harvesters.
+func endHarvesters() {
+	done := make(chan struct{})
+	go func() {
+		harvesting.Wait()
+		testHarvesting.Wait()
+		close(done)
+	}()
+	for stopped := false; !stopped; {
+		// harvesters register when they start, so some may not have yet.
+		if registry != nil {
+			registry.RLock()
+			for _, h := range registry.RunningIds {
+				atomic.StoreInt32(&h.inactive, 1)
+			}
+			registry.RUnlock()
+		}
+		select {
+		case <-done:
+			stopped = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	registry = nil
+	testRegistry()
+	acks = &ackTracker{files: make(map[fileId]*fileAcks)}
+}
+
+// a line without a newline at the end of the file is shipped straight away,
+// and the rest of it, once written, is shipped from the right offset.
+func TestHarvesterEOFWithLine(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntw", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	startReadlines(h, time.Minute)
+
+	start := time.Now()
+	for _, expected := range []string{"one", "tw"} {
+		if e := <-out; e.Text != expected {
+			t.Fatalf("expected %q, got %q", expected, e.Text)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("partial line took %v to ship", elapsed)
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("o\nthree\n")
+	w.Close()
+	for _, expected := range []struct {
+		text   string
+		offset int64
+	}{{"o", 6}, {"three", 8}} {
+		select {
+		case e := <-out:
+			if e.Text != expected.text || e.Offset != expected.offset {
+				t.Fatalf("expected %q at %d, got %q at %d", expected.text, expected.offset, e.Text, e.Offset)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("harvester didn't pick up %q", expected.text)
+		}
+	}
+}
+
+func TestReaderHarvesterRotationGeneration(t *testing.T) {
+	// generations are kept for the life of the process.
+	defer func() {
+		generations.Lock()
+		delete(generations.m, "gen-test")
+		generations.Unlock()
+	}()
+	seedGeneration("gen-test", 2)
+	nextGeneration("gen-test")
+	out := make(chan *FileEvent, 1)
+	h := newReaderHarvester("gen-test", strings.NewReader("one\n"), &FileConfig{RotationGeneration: true}, out)
+	h.readlines(0)
+	if e := <-out; e.Fields["rotation_generation"] != "3" {
+		t.Fatalf("expected rotation_generation 3, got %v", e.Fields)
+	}
+}
+
+func TestHarvesterTimestampMaxAge(t *testing.T) {
+	conf := &FileConfig{}
+	if err := json.Unmarshal([]byte(`{
+		"timestamp_pattern": "^(\\S+) ",
+		"timestamp_layout": "2006-01-02T15:04:05",
+		"timestamp_max_age": 86400
+	}`), conf); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	layout := conf.TimestampLayout
+	input := time.Now().Add(-48*time.Hour).Format(layout) + " old\n" +
+		time.Now().Add(-time.Hour).Format(layout) + " new\n" +
+		"no timestamp\n"
+	events := harvestString(conf, input)
+	if len(events) != 2 || !strings.HasSuffix(events[0].Text, " new") || events[1].Text != "no timestamp" {
+		t.Fatalf("expected the new line and the line without a timestamp, got %v", events)
+	}
+}
+
+func TestParseTimestampWithoutYear(t *testing.T) {
+	p := &pattern{regexp.MustCompile(`^\w{3} [ \d]\d \d\d:\d\d:\d\d`)}
+	now := time.Date(2014, 1, 1, 12, 0, 0, 0, time.Local)
+	ts, ok := parseTimestamp("Dec 31 23:59:59 host app: hi", p, time.Stamp, now)
+	if !ok || ts.Year() != 2013 {
+		t.Fatalf("expected a time in 2013, got %v %v", ts, ok)
+	}
+}
+
+// two harvesters race for the same file.  Only one may read it at a time, and
+// once the winner stops, the other must carry on from where it left off.
+func TestHarvesterHandoff(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	first := fileHarvester(t, "one\n", out)
+	defer os.Remove(first.Path)
+	defer first.file.Close()
+	second := fileHarvester(t, "", out)
+	defer os.Remove(second.Path)
+	defer second.file.Close()
+	second.Path = first.Path
+	f, err := os.Open(first.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	second.file, second.fi = f, first.fi
+	defer endHarvesters()
+
+	firstDone := startReadlines(first, 100*time.Millisecond)
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+	secondDone := startReadlines(second, 300*time.Millisecond)
+
+	<-firstDone
+	w, err := os.OpenFile(first.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("two\n")
+	w.Close()
+	select {
+	case e := <-out:
+		if e.Text != "two" {
+			t.Fatalf("expected two, got %q", e.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("file abandoned once the first harvester stopped")
+	}
+
+	<-secondDone
+	select {
+	case e := <-out:
+		t.Fatalf("unexpected event %q", e.Text)
+	default:
+	}
+}
+
+func TestHarvesterReopenField(t *testing.T) {
+	events := harvestString(&FileConfig{ReopenField: true}, "one\ntwo\n")
+	if len(events) != 2 || events[0].Fields["reopen"] != "true" || events[1].Fields["reopen"] != "" {
+		t.Fatalf("expected only the first event to have reopen set, got %v, %v",
+			events[0].Fields, events[1].Fields)
+	}
+}
+
+func TestHarvesterLineHash(t *testing.T) {
+	events := harvestString(&FileConfig{LineHash: "sha1"}, "one\none\r\n")
+	for _, e := range events {
+		if h := e.Fields["line_hash"]; h != "fe05bcdcdc4928012781a5f1a2a77cbb5398e106" {
+			t.Fatalf("unexpected hash of %q: %q", e.Text, h)
+		}
+	}
+	events = harvestString(&FileConfig{LineHash: "fnv64a", LineHashField: "hash"}, "one\n")
+	if h := events[0].Fields["hash"]; len(h) != 16 {
+		t.Fatalf("expected a 64 bit hash in hash, got %v", events[0].Fields)
+	}
+}
+
+func TestHarvesterOwnerFields(t *testing.T) {
+	out := make(chan *FileEvent, 1)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	if err := h.file.Chmod(0640); err != nil {
+		t.Fatal(err)
+	}
+	info, err := h.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.fi = info
+	h.conf.OwnerFields = true
+
+	e := h.event("one\n", 0, nil)
+	if uid := strconv.Itoa(os.Getuid()); e.Fields["file.owner_uid"] != uid {
+		t.Fatalf("expected owner uid %s, got %q", uid, e.Fields["file.owner_uid"])
+	}
+	if gid := strconv.Itoa(os.Getgid()); e.Fields["file.owner_gid"] != gid {
+		t.Fatalf("expected owner gid %s, got %q", gid, e.Fields["file.owner_gid"])
+	}
+	if e.Fields["file.mode"] != "0640" {
+		t.Fatalf("expected mode 0640, got %q", e.Fields["file.mode"])
+	}
+}
+
+func TestHarvesterTruncationEvent(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntwo\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	h.conf.TruncationEvents = true
+	defer endHarvesters()
+	startReadlines(h, 500*time.Millisecond)
+	for _, expected := range []string{"one", "two"} {
+		if e := <-out; e.Text != expected {
+			t.Fatalf("expected %q, got %q", expected, e.Text)
+		}
+	}
+
+	if err := os.Truncate(h.Path, 0); err != nil {
+		t.Fatal(err)
+	}
+	e := <-out
+	if e.Fields["event_type"] != "truncated" || e.Fields["old_offset"] != "8" || e.Source != h.Path {
+		t.Fatalf("expected a truncated event at 8 for %s, got %+v", h.Path, e)
+	}
+	if trackable(e) {
+		t.Fatal("the truncated event mustn't have a position recorded")
+	}
+}
+
+func TestReaderHarvesterMixedLineEndings(t *testing.T) {
+	conf := &FileConfig{MixedLineEndings: true}
+	events := harvestString(conf, "a\nb\r\nc\rd\r\ne\rf")
+	expected := []struct {
+		text   string
+		offset int64
+	}{
+		{"a", 0}, {"b", 2}, {"c", 5}, {"d", 7}, {"e", 10}, {"f", 12},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range events {
+		if e.Text != expected[i].text || e.Offset != expected[i].offset {
+			t.Fatalf("event %d: expected %q at %d, got %q at %d",
+				i, expected[i].text, expected[i].offset, e.Text, e.Offset)
+		}
+	}
+}
+
+// a \r\n split across two writes, with the harvester reading in between,
+// still ends just the one line.
+func TestHarvesterMixedLineEndingsSplitCRLF(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\r", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	h.conf.MixedLineEndings = true
+	defer endHarvesters()
+	startReadlines(h, 500*time.Millisecond)
+	if e := <-out; e.Text != "one" || e.Offset != 0 {
+		t.Fatalf("expected one at 0, got %q at %d", e.Text, e.Offset)
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("\ntwo\r\n")
+	w.Close()
+	e := <-out
+	if e.Text != "two" || e.Offset != 4 || e.Offset+e.length != 10 {
+		t.Fatalf("expected two at 4 to 10, got %q at %d to %d", e.Text, e.Offset, e.Offset+e.length)
+	}
+}
+
+// lines written to a file after it's deleted, but within the grace period,
+// are still shipped.
+func TestHarvesterGoneGrace(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	h.conf.GoneGraceMs = 300
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.file.Close()
+	defer endHarvesters()
+	done := startReadlines(h, time.Minute)
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+
+	os.Remove(h.Path)
+	time.Sleep(10 * eofPoll)
+	w.WriteString("late\n")
+	w.Close()
+	select {
+	case e := <-out:
+		if e.Text != "late" {
+			t.Fatalf("expected late, got %q", e.Text)
+		}
+	case <-done:
+		t.Fatal("harvester stopped within the grace period")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("harvester didn't stop after the grace period")
+	}
+}
+
+// in create mode a file that shrinks isn't rewound, and the shrink is only
+// logged once, not at every poll.
+func TestHarvesterShrinkLoggedOnce(t *testing.T) {
+	var buf logBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	h := fileHarvester(t, "one\ntwo\n", nil)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	h.conf.Rotation = rotateCreate
+	shrinks := func() int { return strings.Count(buf.String(), h.Path+" shrank") }
+
+	os.Truncate(h.Path, 4)
+	for i := 0; i < 3; i++ {
+		if rewound, err := h.autoRewind(8, nil); rewound || err != nil {
+			t.Fatalf("expected no rewind in create mode, got %v, %v", rewound, err)
+		}
+	}
+	if n := shrinks(); n != 1 {
+		t.Fatalf("expected the shrink to be logged once, got %d", n)
+	}
+	os.Truncate(h.Path, 2)
+	h.autoRewind(8, nil)
+	if n := shrinks(); n != 2 {
+		t.Fatalf("expected a further shrink to be logged, got %d", n)
+	}
+}
+
+// collects what's logged, from whichever goroutine.
+type logBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+// with min_age_ms, a file that's just been written isn't read until it has
+// been left alone for that long.
+func TestHarvesterMinAge(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	clock := newFakeClock()
+	h.clock = clock
+
+	written := clock.Now().Add(-2 * time.Second)
+	if err := os.Chtimes(h.Path, written, written); err != nil {
+		t.Fatal(err)
+	}
+	h.waitForQuiet(5 * time.Second)
+	if waited := clock.Now().Sub(written); waited != 5*time.Second {
+		t.Fatalf("expected to read once the file was 5s old, read at %v", waited)
+	}
+
+	start := clock.Now()
+	h.waitForQuiet(time.Second)
+	if clock.Now() != start {
+		t.Fatal("expected no wait for a file that's already quiet")
+	}
+}
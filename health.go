@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// prospectors that haven't finished a scan in this many scan intervals are
+// considered stuck.
+const prospectorStaleScans = 3
+
+// liveness and readiness state, for the /healthz and /readyz endpoints on the
+// http port.
+var health = struct {
+	sync.Mutex
+	lastScan  map[string]time.Time // last completed scan, by prospector
+	connected map[int]bool         // connection state, by publisher id
+}{
+	lastScan:  make(map[string]time.Time),
+	connected: make(map[int]bool),
+}
+
+func healthScanned(prospector string) {
+	health.Lock()
+	defer health.Unlock()
+	health.lastScan[prospector] = time.Now()
+}
+
+// closed once any publisher has connected.  See waitForConnection.
+var (
+	firstConnection     = make(chan struct{})
+	firstConnectionOnce sync.Once
+)
+
+func healthConnected(publisher int, connected bool) {
+	health.Lock()
+	defer health.Unlock()
+	health.connected[publisher] = connected
+	if connected {
+		firstConnectionOnce.Do(func() { close(firstConnection) })
+	}
+}
+
+// waits until a publisher has connected, or timeout has passed, and reports
+// which.
+func waitForConnection(timeout time.Duration) bool {
+	select {
+	case <-firstConnection:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+type spoolStatus struct {
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+}
+
+type healthStatus struct {
+	Healthy     bool                   `json:"healthy"`
+	Ready       bool                   `json:"ready"`
+	Prospectors map[string]string      `json:"prospectors"` // time since last scan
+	Connected   int                    `json:"publishers_connected"`
+	Spools      map[string]spoolStatus `json:"spools"`
+}
+
+// healthy means every prospector has scanned recently.  ready means we're
+// healthy, at least one publisher is connected, and no spool's input is full.
+func checkHealth(conf *Config) healthStatus {
+	health.Lock()
+	defer health.Unlock()
+
+	s := healthStatus{
+		Healthy:     true,
+		Prospectors: make(map[string]string, len(health.lastScan)),
+		Spools:      make(map[string]spoolStatus, len(conf.Network)),
+	}
+	for name, t := range health.lastScan {
+		age := time.Since(t)
+		s.Prospectors[name] = age.String()
+		if age > prospectorStaleScans*prospectInterval {
+			s.Healthy = false
+		}
+	}
+	if len(health.lastScan) == 0 {
+		s.Healthy = false
+	}
+	for _, connected := range health.connected {
+		if connected {
+			s.Connected++
+		}
+	}
+
+	s.Ready = s.Healthy && s.Connected > 0
+	for name, group := range conf.Network {
+		spool := spoolStatus{Queued: len(group.c_events), Capacity: cap(group.c_events)}
+		if spool.Queued >= spool.Capacity {
+			s.Ready = false
+		}
+		s.Spools[name] = spool
+	}
+	return s
+}
+
+func registerHealthHandlers(conf *Config) {
+	handler := func(ok func(healthStatus) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			s := checkHealth(conf)
+			w.Header().Set("Content-Type", "application/json")
+			if !ok(s) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(s)
+		}
+	}
+	http.HandleFunc("/healthz", handler(func(s healthStatus) bool { return s.Healthy }))
+	http.HandleFunc("/readyz", handler(func(s healthStatus) bool { return s.Ready }))
+}
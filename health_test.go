@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	// listening prospectors other tests have started go on reporting scans.
+	health.Lock()
+	defer func(scans map[string]time.Time, connected map[int]bool) {
+		health.Lock()
+		health.lastScan, health.connected = scans, connected
+		health.Unlock()
+	}(health.lastScan, health.connected)
+	health.lastScan, health.connected = make(map[string]time.Time), make(map[int]bool)
+	health.Unlock()
+
+	events := make(chan *FileEvent, 1)
+	conf := &Config{Network: NetworkConfig{"default": NetworkGroup{c_events: events}}}
+	if s := checkHealth(conf); s.Healthy || s.Ready {
+		t.Fatalf("expected unhealthy before any prospector has scanned, got %+v", s)
+	}
+
+	healthScanned("/var/log/*.log")
+	if s := checkHealth(conf); !s.Healthy || s.Ready {
+		t.Fatalf("expected healthy but not ready with no connections, got %+v", s)
+	}
+
+	healthConnected(1000, true)
+	if s := checkHealth(conf); !s.Ready || s.Connected != 1 {
+		t.Fatalf("expected ready once a publisher connected, got %+v", s)
+	}
+
+	events <- &FileEvent{}
+	if s := checkHealth(conf); s.Ready || s.Spools["default"].Queued != 1 {
+		t.Fatalf("expected not ready with a full spool, got %+v", s)
+	}
+
+	health.Lock()
+	health.lastScan["/var/log/*.log"] = time.Now().Add(-prospectorStaleScans * prospectInterval * 2)
+	health.Unlock()
+	if s := checkHealth(conf); s.Healthy {
+		t.Fatalf("expected unhealthy with a stuck prospector, got %+v", s)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// events handed to the spoolers that the registrar hasn't yet recorded, and
+// the bytes of their text: what's buffered in the spools, the channels between
+// them and the publishers, and the pages being sent.  With
+// -max-inflight-bytes, harvesters stop reading while this is over the limit.
+var inflight struct {
+	events int64
+	bytes  int64
+
+	sync.Mutex // for waiting on released
+	released   *sync.Cond
+}
+
+func init() {
+	inflight.released = sync.NewCond(&inflight.Mutex)
+	expvar.Publish("lsf_inflight_events", expvar.Func(func() interface{} {
+		return atomic.LoadInt64(&inflight.events)
+	}))
+	expvar.Publish("lsf_inflight_bytes", expvar.Func(func() interface{} {
+		return atomic.LoadInt64(&inflight.bytes)
+	}))
+}
+
+// blocks while the events in flight are over -max-inflight-bytes.  An event
+// is let through whenever there's room left under the limit, however big it
+// is, so that a single huge event can't block forever.
+func inflightWait() {
+	max := options.MaxInflightBytes
+	if max <= 0 {
+		return
+	}
+	inflight.Lock()
+	defer inflight.Unlock()
+	for atomic.LoadInt64(&inflight.bytes) >= max {
+		inflight.released.Wait()
+	}
+}
+
+// reports whether inflightWait would block.
+func inflightFull() bool {
+	max := options.MaxInflightBytes
+	return max > 0 && atomic.LoadInt64(&inflight.bytes) >= max
+}
+
+// records that e has been handed to a spooler.
+func inflightSent(e *FileEvent) {
+	atomic.AddInt64(&inflight.events, 1)
+	atomic.AddInt64(&inflight.bytes, int64(len(e.Text)))
+}
+
+// records that the events of page are done with, whether recorded by the
+// registrar or dropped.
+func inflightDone(page eventPage) {
+	var bytes int64
+	for _, e := range page {
+		bytes += int64(len(e.Text))
+	}
+	atomic.AddInt64(&inflight.events, -int64(len(page)))
+	atomic.AddInt64(&inflight.bytes, -bytes)
+	if options.MaxInflightBytes > 0 {
+		inflight.Lock()
+		inflight.released.Broadcast()
+		inflight.Unlock()
+	}
+}
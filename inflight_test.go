@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInflightWait(t *testing.T) {
+	defer func(max int64) { options.MaxInflightBytes = max }(options.MaxInflightBytes)
+	options.MaxInflightBytes = 10
+
+	// other tests send events that are never recorded.
+	defer func(events, bytes int64) {
+		atomic.StoreInt64(&inflight.events, events)
+		atomic.StoreInt64(&inflight.bytes, bytes)
+	}(atomic.LoadInt64(&inflight.events), atomic.LoadInt64(&inflight.bytes))
+	atomic.StoreInt64(&inflight.events, 0)
+	atomic.StoreInt64(&inflight.bytes, 0)
+
+	page := eventPage{{Text: "0123456789"}, {Text: "abc"}}
+	for _, e := range page {
+		inflightSent(e)
+	}
+	if n := atomic.LoadInt64(&inflight.bytes); n != 13 {
+		t.Fatalf("expected 13 bytes in flight, got %d", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		inflightWait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected to wait while over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	inflightDone(page)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected to stop waiting once the page was done")
+	}
+	if n := atomic.LoadInt64(&inflight.events); n != 0 {
+		t.Errorf("expected no events in flight, got %d", n)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// average time to acknowledge a page, by server
+var ackLatencyStat = expvar.NewMap("ack_latency_ms")
+
+// publisherPeers tracks which of the publishers in a network group have been
+// acknowledging pages slowly.  A slow publisher steps back from taking new
+// pages for a while, as long as there's a peer that isn't slow to take them
+// instead.  This shifts load away from servers that are alive but struggling,
+// e.g. in long GC pauses, rather than only away from servers that are down.
+type publisherPeers struct {
+	sync.Mutex
+	slow map[int]bool
+}
+
+func newPublisherPeers() *publisherPeers {
+	return &publisherPeers{slow: make(map[int]bool)}
+}
+
+func (p *publisherPeers) setSlow(id int, slow bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.slow[id] = slow
+}
+
+// whether any publisher other than id isn't slow.
+func (p *publisherPeers) anyFast(id int) bool {
+	p.Lock()
+	defer p.Unlock()
+	for peer, slow := range p.slow {
+		if peer != id && !slow {
+			return true
+		}
+	}
+	return false
+}
+
+// records how long a page took to be acknowledged, and updates whether this
+// publisher counts as slow: its average latency has been over the group's
+// threshold for at least the group's period.
+func (p *Publisher) recordAckLatency(d time.Duration) {
+	// exponentially weighted, so that a single slow ack doesn't count for
+	// much.
+	if p.latency == 0 {
+		p.latency = d
+	} else {
+		p.latency = (p.latency*7 + d) / 8
+	}
+	if p.latencyStat == nil {
+		p.latencyStat = new(expvar.Int)
+		ackLatencyStat.Set(p.name, p.latencyStat)
+	}
+	p.latencyStat.Set(int64(p.latency / time.Millisecond))
+
+	if p.slowAck == 0 || p.peers == nil {
+		return
+	}
+	if p.latency < p.slowAck {
+		p.slowSince = time.Time{}
+		p.peers.setSlow(p.id, false)
+		return
+	}
+	if p.slowSince.IsZero() {
+		p.slowSince = time.Now()
+	}
+	if time.Since(p.slowSince) >= p.slowPeriod {
+		p.peers.setSlow(p.id, true)
+	}
+}
+
+// if this publisher is slow and another one in the group isn't, waits a while
+// before taking another page, so that the faster publishers get it.
+func (p *Publisher) yieldIfSlow() {
+	if p.peers == nil || p.slowSince.IsZero() || time.Since(p.slowSince) < p.slowPeriod {
+		return
+	}
+	if p.peers.anyFast(p.id) {
+		time.Sleep(p.slowPeriod)
+		// we haven't sent anything in a while; give ourselves another
+		// chance.
+		p.latency = p.slowAck
+		p.slowSince = time.Time{}
+		p.peers.setSlow(p.id, false)
+	}
+}
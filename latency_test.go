@@ -0,0 +1,55 @@
+package main
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+// one server acknowledges pages slowly, and the other quickly.  Once the slow
+// one has been slow for the slow ack period, its publisher steps back and the
+// fast one takes the new pages.
+func TestPublisherSlowAck(t *testing.T) {
+	slow := newFakeServer(t, func(int) time.Duration { return 100 * time.Millisecond })
+	defer slow.listener.Close()
+	fast := newFakeServer(t, func(int) time.Duration { return 0 })
+	defer fast.listener.Close()
+
+	peers := newPublisherPeers()
+	retries := make(chan failedPage, 2)
+	slowPublisher := testPublisher(1101, slow, peers, retries)
+	fastPublisher := testPublisher(1102, fast, peers, retries)
+	for _, p := range []*Publisher{slowPublisher, fastPublisher} {
+		p.slowAck, p.slowPeriod = 20*time.Millisecond, 400*time.Millisecond
+	}
+
+	input, registrar := make(chan eventPage), make(chan eventPage, 1024)
+	wait := runPublishers([]*Publisher{slowPublisher, fastPublisher}, input, registrar)
+	start := time.Now()
+	for time.Since(start) < time.Second {
+		input <- eventPage{&FileEvent{Text: "line"}}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(input)
+	wait()
+
+	if n := slow.pagesBetween(start, start.Add(300*time.Millisecond)); n == 0 {
+		t.Fatal("expected the slow server to get pages before it was found slow")
+	}
+	// found slow after about 500ms, and then steps back for 400ms.
+	from, to := start.Add(650*time.Millisecond), start.Add(900*time.Millisecond)
+	if n := slow.pagesBetween(from, to); n != 0 {
+		t.Fatalf("expected the slow server to get no pages once found slow, got %d", n)
+	}
+	if n := fast.pagesBetween(from, to); n == 0 {
+		t.Fatal("expected the fast server to take the pages the slow one didn't")
+	}
+
+	latency := func(p *Publisher) int64 { return ackLatencyStat.Get(p.name).(*expvar.Int).Value() }
+	if ms := latency(slowPublisher); ms < 20 {
+		t.Errorf("expected the slow server's latency stat to be over 20ms, got %d", ms)
+	}
+	if ms := latency(fastPublisher); ms >= 20 {
+		t.Errorf("expected the fast server's latency stat to be under 20ms, got %d", ms)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// limits on the total amount of data lumberjack will ship, set with
+// -max-events and -max-bytes.  Once either limit is reached, harvesters stop
+// reading.  With -limit-action=exit, lumberjack exits once every event it has
+// handed off has been acknowledged and recorded by the registrar; with
+// -limit-action=pause it stays up, idle.
+var limits struct {
+	events  int64 // events handed to the spoolers
+	bytes   int64 // bytes of event text handed to the spoolers
+	acked   int64 // events recorded by the registrar
+	reached int32 // set to 1 once a limit has been hit
+}
+
+func limitsEnabled() bool {
+	return options.MaxEvents > 0 || options.MaxBytes > 0
+}
+
+func limitReached() bool {
+	return atomic.LoadInt32(&limits.reached) == 1
+}
+
+// reserves room under the configured limits for copies of an event of size
+// bytes.  Each copy of an event sent to more than one network group is
+// acknowledged, and counted by the registrar, separately.  Returns false if
+// shipping the copies would exceed a limit, in which case the event must be
+// dropped.  Its offset is never recorded, so it will be read again on the
+// next run.
+func limitReserve(copies, size int) bool {
+	if !limitsEnabled() {
+		return true
+	}
+	if limitReached() {
+		return false
+	}
+	n, b := int64(copies), int64(copies)*int64(size)
+	events := atomic.AddInt64(&limits.events, n)
+	bytes := atomic.AddInt64(&limits.bytes, b)
+	if (options.MaxEvents > 0 && events > options.MaxEvents) ||
+		(options.MaxBytes > 0 && bytes > options.MaxBytes) {
+		atomic.AddInt64(&limits.events, -n)
+		atomic.AddInt64(&limits.bytes, -b)
+		if atomic.CompareAndSwapInt32(&limits.reached, 0, 1) {
+			log.Printf("shipping limit reached after %d events, %d bytes",
+				atomic.LoadInt64(&limits.events), atomic.LoadInt64(&limits.bytes))
+			limitCheckDone()
+		}
+		return false
+	}
+	return true
+}
+
+// called by the registrar once n events have been recorded.
+func limitAcked(n int) {
+	if !limitsEnabled() {
+		return
+	}
+	atomic.AddInt64(&limits.acked, int64(n))
+	limitCheckDone()
+}
+
+// exits if a limit has been reached and everything shipped so far has been
+// recorded.
+func limitCheckDone() {
+	if !limitReached() || options.LimitAction != "exit" {
+		return
+	}
+	if atomic.LoadInt64(&limits.acked) >= atomic.LoadInt64(&limits.events) {
+		log.Println("all events up to the shipping limit recorded, exiting")
+		exit()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLimitReserve(t *testing.T) {
+	defer func(events, bytes int64, action string) {
+		options.MaxEvents, options.MaxBytes, options.LimitAction = events, bytes, action
+		limits.events, limits.bytes, limits.acked, limits.reached = 0, 0, 0, 0
+	}(options.MaxEvents, options.MaxBytes, options.LimitAction)
+	options.MaxEvents, options.MaxBytes, options.LimitAction = 3, 10, "pause"
+
+	for i, size := range []int{4, 4} {
+		if !limitReserve(1, size) {
+			t.Fatalf("event %d refused under the limits", i)
+		}
+	}
+	// over max-bytes, so refused, and nothing more is let through even if
+	// it would fit.
+	if limitReserve(1, 4) {
+		t.Fatal("expected an event past -max-bytes to be refused")
+	}
+	if !limitReached() {
+		t.Fatal("expected the limit to be reached")
+	}
+	if limitReserve(1, 1) {
+		t.Fatal("expected nothing more once the limit was reached")
+	}
+	if limits.events != 2 || limits.bytes != 8 {
+		t.Fatalf("expected the refused events not to be counted, got %d events, %d bytes", limits.events, limits.bytes)
+	}
+}
+
+// the harvester stops reading once the limit is reached.
+func TestReaderHarvesterLimit(t *testing.T) {
+	defer func(events int64, action string) {
+		options.MaxEvents, options.LimitAction = events, action
+		limits.events, limits.bytes, limits.acked, limits.reached = 0, 0, 0, 0
+	}(options.MaxEvents, options.LimitAction)
+	options.MaxEvents, options.LimitAction = 2, "pause"
+
+	events := harvestString(nil, "one\ntwo\nthree\nfour\n")
+	if len(events) != 2 || events[1].Text != "two" {
+		t.Fatalf("expected the first 2 events, got %d", len(events))
+	}
+}
+
+// an event sent to an error_dest as well is two events shipped, each
+// acknowledged separately, so lumberjack mustn't exit until both have been.
+func TestLimitReserveCopies(t *testing.T) {
+	defer func(events int64, action string) {
+		options.MaxEvents, options.LimitAction = events, action
+		limits.events, limits.bytes, limits.acked, limits.reached = 0, 0, 0, 0
+	}(options.MaxEvents, options.LimitAction)
+	options.MaxEvents, options.LimitAction = 3, "pause"
+
+	if !limitReserve(2, 5) || limits.events != 2 || limits.bytes != 10 {
+		t.Fatalf("expected both copies to be counted, got %d events, %d bytes", limits.events, limits.bytes)
+	}
+	if limitReserve(2, 5) {
+		t.Fatal("expected copies past -max-events to be refused")
+	}
+	if limits.events != 2 {
+		t.Fatalf("expected none of the refused copies to be counted, got %d events", limits.events)
+	}
+}
+
+func TestReaderHarvesterLimitErrorDest(t *testing.T) {
+	defer func(events int64, action string) {
+		options.MaxEvents, options.LimitAction = events, action
+		limits.events, limits.bytes, limits.acked, limits.reached = 0, 0, 0, 0
+	}(options.MaxEvents, options.LimitAction)
+	options.MaxEvents, options.LimitAction = 10, "pause"
+
+	errors := make(chan *FileEvent, 4)
+	conf := &FileConfig{ErrorPattern: &pattern{regexp.MustCompile("ERROR")}, errorOut: errors}
+	events := harvestString(conf, "ok\nERROR bad\n")
+	if len(events) != 2 || len(errors) != 1 {
+		t.Fatalf("expected 2 events and 1 error copy, got %d and %d", len(events), len(errors))
+	}
+	if limits.events != 3 {
+		t.Fatalf("expected every copy to count toward the limit, got %d", limits.events)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+// hashes of each event's text, chosen per entry in files with "line_hash".
+// Unlike the source and offset, the hash only depends on the content, so
+// the same line read from different files, or read twice, hashes the same.
+var lineHashes = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"fnv64a": func() hash.Hash { return fnv.New64a() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// the field line hashes are stored in, unless line_hash_field says otherwise.
+const defaultLineHashField = "line_hash"
+
+// sets the line hash field of e to the hex encoded hash of its text.
+func (h *Harvester) hashLine(e *FileEvent) {
+	hash := lineHashes[h.conf.LineHash]()
+	hash.Write([]byte(e.Text))
+	field := h.conf.LineHashField
+	if field == "" {
+		field = defaultLineHashField
+	}
+	e.Fields[field] = hex.EncodeToString(hash.Sum(nil))
+}
@@ -52,9 +52,12 @@ func rmPidfile() {
 }
 
 func awaitSignals() {
-	die, hup := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	die, hup, usr1 := make(chan os.Signal, 1), make(chan os.Signal, 1), make(chan os.Signal, 1)
+	usr2 := make(chan os.Signal, 1)
 	signal.Notify(die, os.Interrupt, os.Kill)
 	signal.Notify(hup, syscall.SIGHUP)
+	notifyPause(usr1)
+	notifyFlush(usr2)
 	for {
 		select {
 		case <-die:
@@ -62,6 +65,16 @@ func awaitSignals() {
 			shutdown(nil)
 		case <-hup:
 			refreshLogfileHandle()
+			if err := reloadFieldsFile(); err != nil {
+				log.Printf("ERROR unable to reload fields file: %v", err)
+			}
+			if err := reloadConfigFields(); err != nil {
+				log.Printf("ERROR unable to reload fields from config: %v", err)
+			}
+		case <-usr1:
+			togglePause()
+		case <-usr2:
+			requestFlush()
 		}
 	}
 }
@@ -111,26 +124,68 @@ func shutdown(v interface{}) {
 	log.Fatal(v)
 }
 
+// like shutdown, but for when lumberjack has finished its work and is exiting
+// normally.
+func exit() {
+	for _, fn := range shutdownHandlers {
+		fn()
+	}
+	os.Exit(0)
+}
+
 var publisherId = 0
 
 func startPublishers(conf NetworkConfig, out chan eventPage) error {
 	for _, group := range conf {
+		if group.Output == outputStdout {
+			go publishStdout(publisherId, group.c_pages_unsent, out)
+			publisherId++
+			continue
+		}
 		tlsConfig, err := group.TLS()
 		if err != nil {
 			return fmt.Errorf("unable to start publishers: %v", err)
 		}
 
+		workers := group.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		peers := newPublisherPeers()
+		// each publisher hands back at most the one page it's sending, and
+		// takes back a failed page before a new one, so this never fills.
+		retries := make(chan failedPage, len(group.Servers)*workers)
 		for _, server := range group.Servers {
-			p := &Publisher{
-				id:        publisherId,
-				sequence:  1,
-				addr:      server,
-				tlsConfig: *tlsConfig,
-				timeout:   group.timeout,
+			for worker := 0; worker < workers; worker++ {
+				p := &Publisher{
+					id:            publisherId,
+					sequence:      1,
+					addr:          server,
+					name:          server,
+					tlsConfig:     *tlsConfig,
+					timeout:       group.timeout,
+					serialization: group.Serialization,
+					maxRetries:    group.MaxRetries,
+					maxEventAge:   time.Duration(group.MaxEventAge) * time.Second,
+					deadLetter:    group.DeadLetter,
+					peers:         peers,
+					slowAck:       time.Duration(group.SlowAckMs) * time.Millisecond,
+					slowPeriod:    group.slowAckPeriod(),
+					retries:       retries,
+				}
+				if group.Faults.enabled() {
+					log.Printf("WARNING injecting faults into connections to %s: %+v", server, *group.Faults)
+					p.faults, p.faultRand = group.Faults, group.Faults.source(publisherId)
+				}
+				if workers > 1 {
+					p.name = fmt.Sprintf("%s#%d", server, worker)
+				}
+				p.stats = newPublisherStats(p.name)
+				peers.setSlow(p.id, false)
+				log.Printf("TLS config: %v\n", tlsConfig)
+				go p.publish(group.c_pages_unsent, out)
+				publisherId++
 			}
-			log.Printf("TLS config: %v\n", tlsConfig)
-			go p.publish(group.c_pages_unsent, out)
-			publisherId++
 		}
 	}
 	return nil
@@ -139,7 +194,7 @@ func startPublishers(conf NetworkConfig, out chan eventPage) error {
 func startHttp() {
 	if options.HttpPort != "" {
 		log.Printf("starting http debug port on %s", options.HttpPort)
-		if err := http.ListenAndServe(options.HttpPort, nil); err != nil {
+		if err := http.ListenAndServe(options.HttpPort, withoutPprof(http.DefaultServeMux)); err != nil {
 			log.Printf("unable to open http port: %v", err)
 		}
 	} else {
@@ -161,6 +216,11 @@ func handleArgs() {
 			shutdown("not enough arguments specified for test-config")
 		}
 		testConfig(flag.Arg(1))
+	case "registry":
+		if flag.NArg() < 2 || flag.Arg(1) != "dump" {
+			shutdown("usage: registry dump")
+		}
+		registryDump(options.HistoryPath)
 	default:
 		shutdown(fmt.Sprintf("unrecognized positional arg: %v", flag.Arg(0)))
 	}
@@ -177,9 +237,43 @@ func main() {
 
 	startCPUProfile()
 
+	if options.LimitAction != "exit" && options.LimitAction != "pause" {
+		shutdown(fmt.Sprintf("invalid -limit-action %q: must be exit or pause", options.LimitAction))
+	}
+
+	if options.Watcher != "notify" && options.Watcher != "poll" {
+		shutdown(fmt.Sprintf("invalid -watcher %q: must be notify or poll", options.Watcher))
+	}
+	if options.ScanInterval <= 0 || options.EOFPoll <= 0 {
+		shutdown("-scan-interval and -eof-poll must be positive")
+	}
+	prospectInterval, eofPoll = options.ScanInterval, options.EOFPoll
+	if options.ProgressStore != "file" && options.ProgressStore != "xattr" {
+		shutdown(fmt.Sprintf("invalid -progress-store %q: must be file or xattr", options.ProgressStore))
+	}
+	if err := setFileIdentity(options.FileIdentity); err != nil {
+		shutdown(err.Error())
+	}
+
 	config, err := LoadConfig(options.ConfigFile)
 	if err != nil {
-		fmt.Println(err)
+		// stdout may be carrying events to another process.
+		fmt.Fprintln(os.Stderr, err)
+		shutdown(err.Error())
+	}
+
+	if config.AgentFields != "" {
+		agent := agentFields(config.AgentFields)
+		for k, v := range config.Fields {
+			agent[k] = v
+		}
+		config.Fields = agent
+	}
+	setGlobalFields(config.Fields)
+	if options.FieldsFile == "" {
+		options.FieldsFile = config.FieldsFile
+	}
+	if err := reloadFieldsFile(); err != nil {
 		shutdown(err.Error())
 	}
 
@@ -188,15 +282,15 @@ func main() {
 
 	registrar_chan := make(chan eventPage, 1)
 
-	if len(config.Files) == 0 {
+	if len(config.Files) == 0 && options.PathsFrom == "" {
 		shutdown("No paths given. What files do you want me to watch?\n")
 	}
 
-	go reportFSEvents()
-	// Prospect the globs/paths given on the command line and launch harvesters
-	for _, fileconfig := range config.Files {
-		go Prospect(fileconfig, config.Network)
+	startWatcher()
+	if config.HarvesterStartupStagger != nil {
+		staggerHarvesters(config.HarvesterStartupStagger)
 	}
+	go startHarvesting(config)
 
 	// Harvesters dump events into the spooler.
 	for _, group := range config.Network {
@@ -208,11 +302,39 @@ func main() {
 	}
 
 	// registrar records last acknowledged positions in all files.
-	go Registrar(registrar_chan)
+	supervise("registrar", func() { Registrar(registrar_chan) })
+	registerHealthHandlers(config)
+	registerHarvesterHandler()
 	go startHttp()
+	go startPprof()
 	awaitSignals()
 }
 
+// starts the prospectors, or harvests -paths-from.  With -wait-for-connection,
+// nothing is read until a publisher has connected, so that events don't pile
+// up in memory while the servers are unreachable.  If none connects in time,
+// harvesting starts anyway.
+func startHarvesting(config *Config) {
+	if options.WaitForConnection > 0 {
+		log.Printf("waiting up to %v for a connection before harvesting", options.WaitForConnection)
+		if !waitForConnection(options.WaitForConnection) {
+			log.Printf("WARNING no connection after %v, harvesting anyway", options.WaitForConnection)
+		}
+	}
+	if options.PathsFrom != "" {
+		// harvest just the listed files, and exit once they've been shipped.
+		if err := harvestPathsFrom(options.PathsFrom, config.Network.EventChan("default")); err != nil {
+			shutdown(err.Error())
+		}
+		log.Println("all listed files shipped, exiting")
+		exit()
+	}
+	// Prospect the globs/paths given on the command line and launch harvesters
+	for _, fileconfig := range config.Files {
+		go Prospect(fileconfig, config.Network)
+	}
+}
+
 func startCPUProfile() {
 	if options.CPUProfile != "" {
 		f, err := os.Create(options.CPUProfile)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+// serializations of events on the wire, chosen per network group with
+// "serialization".  The downstream must understand the one chosen.
+const (
+	serializeKV      = "kv"      // 'D' data frames, the default
+	serializeMsgpack = "msgpack" // 'M' frames.  See writeMsgpackFrame.
+)
+
+// writes e as an 'M' frame: the sequence number, then the same keys and
+// values as a data frame, as a MessagePack map of strings.  See PROTOCOL.md.
+func (e *FileEvent) writeMsgpackFrame(w io.Writer, id uint32) {
+	w.Write([]byte("1M"))
+	binary.Write(w, binary.BigEndian, id)
+
+	writeMsgpackMapHeader(w, len(e.Fields)+4)
+	writeMsgpackString(w, "file")
+	writeMsgpackString(w, e.Source)
+	writeMsgpackString(w, "host")
+	writeMsgpackString(w, hostname)
+	writeMsgpackString(w, "offset")
+	writeMsgpackString(w, strconv.FormatInt(e.Offset, 10))
+	writeMsgpackString(w, e.messageKey())
+	writeMsgpackString(w, e.Text)
+	for k, v := range e.Fields {
+		writeMsgpackString(w, k)
+		writeMsgpackString(w, v)
+	}
+}
+
+func writeMsgpackMapHeader(w io.Writer, n int) {
+	switch {
+	case n < 16:
+		w.Write([]byte{0x80 | byte(n)})
+	case n < 1<<16:
+		w.Write([]byte{0xde, byte(n >> 8), byte(n)})
+	default:
+		w.Write([]byte{0xdf})
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackString(w io.Writer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		w.Write([]byte{0xa0 | byte(n)})
+	case n < 1<<8:
+		w.Write([]byte{0xd9, byte(n)})
+	case n < 1<<16:
+		w.Write([]byte{0xda, byte(n >> 8), byte(n)})
+	default:
+		w.Write([]byte{0xdb})
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+	io.WriteString(w, s)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackFrame(t *testing.T) {
+	defer func(h string) { hostname = h }(hostname)
+	hostname = "h"
+	e := &FileEvent{Source: "f", Offset: 7, Text: strings.Repeat("x", 40)}
+
+	var buf bytes.Buffer
+	e.writeMsgpackFrame(&buf, 1)
+	expected := "1M\x00\x00\x00\x01" + "\x84" +
+		"\xa4file\xa1f" + "\xa4host\xa1h" + "\xa6offset\xa17" +
+		"\xa4line\xd9\x28" + strings.Repeat("x", 40)
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func benchmarkFrame(b *testing.B, serialization string) {
+	page := make(eventPage, 100)
+	for i := range page {
+		page[i] = &FileEvent{
+			Source: "/var/log/app.log",
+			Offset: int64(i * 100),
+			Text:   "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.0\" 200 2326",
+			Fields: map[string]string{"type": "apache", "rotated": "false"},
+		}
+	}
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		page.compress(1, &buf, serialization)
+	}
+	b.ReportMetric(float64(buf.Len())/float64(len(page)), "compressed-bytes/event")
+}
+
+func BenchmarkFrameKV(b *testing.B)      { benchmarkFrame(b, serializeKV) }
+func BenchmarkFrameMsgpack(b *testing.B) { benchmarkFrame(b, serializeMsgpack) }
@@ -20,6 +20,28 @@ var options struct {
 	NumThreads    int
 	CmdPort       int
 	HttpPort      string
+	PprofAddr     string
+	FieldsFile    string
+	MaxEvents     int64
+	MaxBytes      int64
+	LimitAction   string
+	ProgressStore string
+	PathsFrom     string
+	Watcher       string
+	ScanInterval  time.Duration
+	EOFPoll       time.Duration
+
+	MaxInflightBytes int64
+
+	WaitForConnection time.Duration
+
+	MaxProgressEntries int
+	ResumeWindow       int
+
+	CleanRemoved      bool
+	CleanRemovedGrace time.Duration
+
+	FileIdentity string
 }
 
 func init() {
@@ -30,7 +52,7 @@ func init() {
 		"deprecated option, strictly for backwards compatibility. does nothing.")
 	flag.DurationVar(&options.IdleTimeout, "idle-flush-time", 5*time.Second,
 		"Maximum time to wait for a full spool before flushing anyway")
-	flag.StringVar(&options.ConfigFile, "config", "", "The config file to load")
+	flag.StringVar(&options.ConfigFile, "config", "", "The config file to load, or a directory of .conf files to merge")
 	flag.StringVar(&options.LogFile, "log-file", "", "Log file output")
 	flag.StringVar(&options.PidFile, "pid-file", "lumberjack.pid",
 		"destination to which a pidfile will be written")
@@ -46,4 +68,38 @@ func init() {
 	flag.IntVar(&options.CmdPort, "cmd-port", 42586, "tcp command port number")
 	flag.StringVar(&options.HttpPort, "http", "",
 		"http port for debug info. No http server is run if this is left off. E.g.: http=:6060")
+	flag.StringVar(&options.PprofAddr, "pprof", "",
+		"address to serve net/http/pprof profiles on. Off if left empty. E.g.: pprof=localhost:6061")
+	flag.StringVar(&options.FieldsFile, "fields-file", "",
+		"JSON file, or YAML if named .yml or .yaml, of fields to add to every event. Overrides the config's fields_file. Reloaded on SIGHUP.")
+	flag.Int64Var(&options.MaxEvents, "max-events", 0,
+		"Stop harvesting after this many events have been shipped. 0 means no limit.")
+	flag.Int64Var(&options.MaxBytes, "max-bytes", 0,
+		"Stop harvesting after this many bytes of event text have been shipped. 0 means no limit.")
+	flag.Int64Var(&options.MaxInflightBytes, "max-inflight-bytes", 0,
+		"Stop reading while this many bytes of event text are waiting to be acknowledged. 0 means no limit.")
+	flag.DurationVar(&options.WaitForConnection, "wait-for-connection", 0,
+		"On start up, wait up to this long for a connection to a server before harvesting anything. 0 means don't wait.")
+	flag.StringVar(&options.LimitAction, "limit-action", "exit",
+		"What to do once -max-events or -max-bytes is reached: exit or pause")
+	flag.StringVar(&options.ProgressStore, "progress-store", "file",
+		"Where to record the position reached in each file: file, for the -progress-file, or xattr, for an extended attribute of each file")
+	flag.StringVar(&options.PathsFrom, "paths-from", "",
+		"Harvest the files listed in this file, or on stdin if -, instead of those in the config, and exit once they're shipped")
+	flag.StringVar(&options.Watcher, "watcher", "notify",
+		"How to notice rotated files: notify, watching directories with inotify, or poll, checking files periodically")
+	flag.DurationVar(&options.ScanInterval, "scan-interval", 10*time.Second,
+		"How often to check the paths in the config for new and rotated files")
+	flag.DurationVar(&options.EOFPoll, "eof-poll", time.Second,
+		"How often a harvester at the end of its file checks it for more data")
+	flag.IntVar(&options.MaxProgressEntries, "max-progress-entries", 0,
+		"Most files to keep in the progress file, dropping the least recently modified files not being harvested. 0 means no limit.")
+	flag.IntVar(&options.ResumeWindow, "resume-window", 0,
+		"Record a checksum of this many bytes before each file's position, and only resume files where they still match. 0 means don't.")
+	flag.BoolVar(&options.CleanRemoved, "clean-removed", false,
+		"Periodically remove files that no longer exist from the progress file")
+	flag.DurationVar(&options.CleanRemovedGrace, "clean-removed-grace", time.Hour,
+		"How long a file must be gone before -clean-removed removes it")
+	flag.StringVar(&options.FileIdentity, "file-identity", "inode",
+		"How to tell files apart: inode, by device and inode, or inode_birth, adding when the file was created, where stat records it")
 }
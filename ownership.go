@@ -0,0 +1,45 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// user names by uid, looked up once each.  A uid with no name maps to "".
+var (
+	userNames     = make(map[uint32]string)
+	userNamesLock sync.Mutex
+)
+
+func userName(uid uint32) string {
+	userNamesLock.Lock()
+	defer userNamesLock.Unlock()
+	name, ok := userNames[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+			name = u.Username
+		}
+		userNames[uid] = name
+	}
+	return name
+}
+
+// adds the owner and permissions of the file an event was read from to its
+// fields, for owner_fields.
+func ownerFields(info os.FileInfo, fields map[string]string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	fields["file.owner_uid"] = strconv.FormatUint(uint64(stat.Uid), 10)
+	fields["file.owner_gid"] = strconv.FormatUint(uint64(stat.Gid), 10)
+	fields["file.mode"] = "0" + strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+	if name := userName(stat.Uid); name != "" {
+		fields["file.owner"] = name
+	}
+}
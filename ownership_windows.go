@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+)
+
+func ownerFields(info os.FileInfo, fields map[string]string) {
+	// files on windows have ACLs rather than an owner and mode bits.
+}
@@ -8,22 +8,35 @@ import (
 
 type eventPage []*FileEvent
 
+// progress acknowledges the events of the page, and returns the positions it
+// is now safe to resume each file in the page from.  See ackTracker.
 func (p *eventPage) progress() progress {
 	prog := make(progress)
 
+	safe := acks.acknowledge(*p)
 	for _, event := range *p {
-		if event.Source == "-" || event.Source == "" || event.Rotated {
+		if !trackable(event) {
 			continue
 		}
 
+		offset, ok := safe[filestring(event.fileinfo)]
+		if !ok {
+			// from a file that's been deleted.
+			continue
+		}
 		ino, dev := file_ids(event.fileinfo)
 		prog[event.Source] = &FileState{
-			Source: event.Source,
-			Offset: event.Offset + int64(len(event.Text)) + 1,
-			Inode:  ino,
-			Device: dev,
+			Source:     event.Source,
+			Offset:     offset,
+			Inode:      ino,
+			Device:     dev,
+			Generation: generation(event.Source),
+			Birth:      birthStamp(event.fileinfo),
 		}
 	}
+	if options.ResumeWindow > 0 {
+		prog.addChecksums(options.ResumeWindow)
+	}
 
 	return prog
 }
@@ -56,8 +69,9 @@ func (p *eventPage) empty() bool {
 	return len(*p) == 0
 }
 
-// compress the event page into the destination buffer
-func (p *eventPage) compress(sequenceId uint32, buf *bytes.Buffer) error {
+// compress the event page into the destination buffer, serializing events
+// as given by serialization.
+func (p *eventPage) compress(sequenceId uint32, buf *bytes.Buffer, serialization string) error {
 	buf.Reset()
 	z, err := zlib.NewWriterLevel(buf, 3)
 	if err != nil {
@@ -65,7 +79,11 @@ func (p *eventPage) compress(sequenceId uint32, buf *bytes.Buffer) error {
 	}
 
 	for i, e := range *p {
-		e.writeFrame(z, sequenceId+uint32(i))
+		if serialization == serializeMsgpack {
+			e.writeMsgpackFrame(z, sequenceId+uint32(i))
+		} else {
+			e.writeFrame(z, sequenceId+uint32(i))
+		}
 	}
 	if err := z.Flush(); err != nil {
 		return fmt.Errorf("unable to compress eventPage: %v", err)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// harvests exactly the files listed in the file at src, or on stdin if src is
+// "-", from the beginning, and returns once everything in them has been read
+// and acknowledged.  Each line of the list is a path, optionally followed by
+// fields to add to the events from that file, as in the replay command:
+//
+//	/var/log/app.log incident=1234 host=web1
+func harvestPathsFrom(src string, out chan *FileEvent) error {
+	if out == nil {
+		return fmt.Errorf("no default network group to send listed files to")
+	}
+	var r io.Reader = os.Stdin
+	if src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			return fmt.Errorf("unable to open paths file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var harvesters []*Harvester
+	var drained []chan struct{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		path := parts[0]
+		fields := make(map[string]string, len(parts)-1)
+		for _, field := range parts[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("unable to parse field %q for %s", field, path)
+			}
+			fields[kv[0]] = kv[1]
+		}
+		if info, err := os.Stat(path); err != nil {
+			log.Printf("WARNING skipping %s: %v", path, err)
+			continue
+		} else if info.IsDir() {
+			log.Printf("WARNING skipping %s: it's a directory", path)
+			continue
+		}
+		h := newHarvester(path, &FileConfig{Fields: fields}, out)
+		d := make(chan struct{})
+		h.drained = d
+		harvesters, drained = append(harvesters, h), append(drained, d)
+		go h.Harvest(0, h_Rewind)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read paths: %v", err)
+	}
+	log.Printf("harvesting %d files listed in %s", len(harvesters), src)
+
+	for _, d := range drained {
+		<-d
+	}
+	for {
+		done := true
+		for _, h := range harvesters {
+			if id, err := h.fileId(); err == nil && acks.unacknowledged(id) {
+				done = false
+				break
+			}
+		}
+		if done {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// lumberjack can be paused, e.g. for a downstream maintenance window.  While
+// paused, harvesters hold at their current offset without reading, and
+// publishers hold on to their pages without sending.  Connections, harvester
+// state and the registry are all left as they are, so on resume everything
+// continues from where it was.
+//
+// Pausing is toggled with SIGUSR1, or with the pause and resume commands on
+// the command port.
+var pauseState struct {
+	sync.Mutex
+	resumed chan struct{} // closed on resume.  nil while running.
+}
+
+// returns false if lumberjack was already paused.
+func pause() bool {
+	pauseState.Lock()
+	defer pauseState.Unlock()
+	if pauseState.resumed != nil {
+		return false
+	}
+	pauseState.resumed = make(chan struct{})
+	log.Println("lumberjack paused")
+	return true
+}
+
+// returns false if lumberjack wasn't paused.
+func resume() bool {
+	pauseState.Lock()
+	defer pauseState.Unlock()
+	if pauseState.resumed == nil {
+		return false
+	}
+	close(pauseState.resumed)
+	pauseState.resumed = nil
+	log.Println("lumberjack resumed")
+	return true
+}
+
+func togglePause() {
+	if !pause() {
+		resume()
+	}
+}
+
+// blocks for as long as lumberjack is paused.
+func waitWhilePaused() {
+	pauseState.Lock()
+	c := pauseState.resumed
+	pauseState.Unlock()
+	if c != nil {
+		<-c
+	}
+}
+
+var pauseCmd = cmd{
+	name: "pause",
+	run: func(args []string, w io.Writer) {
+		if pause() {
+			fmt.Fprintln(w, "ok")
+		} else {
+			fmt.Fprintln(w, "already paused")
+		}
+	},
+}
+
+var resumeCmd = cmd{
+	name: "resume",
+	run: func(args []string, w io.Writer) {
+		if resume() {
+			fmt.Fprintln(w, "ok")
+		} else {
+			fmt.Fprintln(w, "not paused")
+		}
+	},
+}
+
+func init() {
+	registerCmd(pauseCmd)
+	registerCmd(resumeCmd)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// a paused harvester holds where it is, and carries on once resumed.
+func TestPauseHarvester(t *testing.T) {
+	if !pause() {
+		t.Fatal("expected to pause")
+	}
+	defer resume()
+	if pause() {
+		t.Fatal("expected pausing again to do nothing")
+	}
+
+	out := make(chan *FileEvent, 1)
+	h := newReaderHarvester("test", strings.NewReader("one\n"), nil, out)
+	done := make(chan struct{})
+	go func() {
+		h.readlines(0)
+		close(done)
+	}()
+	select {
+	case e := <-out:
+		t.Fatalf("unexpected event %q while paused", e.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	togglePause()
+	select {
+	case e := <-out:
+		if e.Text != "one" {
+			t.Fatalf("expected one, got %q", e.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("harvester didn't carry on once resumed")
+	}
+	<-done
+	if resume() {
+		t.Fatal("expected resuming when running to do nothing")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// the net/http/pprof package registers its handlers on the default mux as a
+// side effect of being imported, which would put them on the -http port.
+// Profiles can be expensive to take and reveal a lot about the process, so
+// they are only served on the separate -pprof address, and only if asked.
+const pprofPrefix = "/debug/pprof/"
+
+func startPprof() {
+	if options.PprofAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(pprofPrefix, pprof.Index)
+	mux.HandleFunc(pprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPrefix+"trace", pprof.Trace)
+	log.Printf("starting pprof on %s", options.PprofAddr)
+	if err := http.ListenAndServe(options.PprofAddr, mux); err != nil {
+		log.Printf("unable to open pprof port: %v", err)
+	}
+}
+
+// withoutPprof hides the pprof handlers that were registered on h.
+func withoutPprof(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, pprofPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// a processor is one step of the "processors" list of a prospector, a small
+// set of field transforms applied to every event, in order, once the event
+// has otherwise been built.  Exactly one of the kinds of transform must be
+// given per step:
+//
+//   - rename: {"old": "new", ...} renames fields.  All the renames in a step
+//     happen at once, so {"a": "b", "b": "a"} swaps two fields.
+//   - drop_fields: ["name", ...] removes fields.
+//   - lowercase: ["name", ...] lowercases the values of fields.
+//   - add_field: {"name": "value", ...} sets fields, replacing any existing
+//     values.
+//   - redact: masks or hashes whatever matches patterns in the text and
+//     fields of events.  See redaction.
+//
+// Fields a step refers to that an event doesn't have are ignored.
+type processor struct {
+	Rename     map[string]string `json:"rename"`
+	DropFields []string          `json:"drop_fields"`
+	Lowercase  []string          `json:"lowercase"`
+	AddField   map[string]string `json:"add_field"`
+	Redact     *redaction        `json:"redact"`
+}
+
+func (p *processor) UnmarshalJSON(b []byte) error {
+	type plain processor
+	if err := json.Unmarshal(b, (*plain)(p)); err != nil {
+		return fmt.Errorf("cannot unmarshal processor: %v", err)
+	}
+	kinds := 0
+	for _, set := range []bool{p.Rename != nil, p.DropFields != nil, p.Lowercase != nil, p.AddField != nil, p.Redact != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("illegal processor %s: must have exactly one of rename, drop_fields, lowercase, add_field or redact", b)
+	}
+	return nil
+}
+
+func (p *processor) apply(e *FileEvent) {
+	fields := e.Fields
+	switch {
+	case p.Rename != nil:
+		values := make(map[string]string, len(p.Rename))
+		for from := range p.Rename {
+			if v, ok := fields[from]; ok {
+				values[from] = v
+				delete(fields, from)
+			}
+		}
+		for from, v := range values {
+			fields[p.Rename[from]] = v
+		}
+	case p.DropFields != nil:
+		for _, k := range p.DropFields {
+			delete(fields, k)
+		}
+	case p.Lowercase != nil:
+		for _, k := range p.Lowercase {
+			if v, ok := fields[k]; ok {
+				fields[k] = strings.ToLower(v)
+			}
+		}
+	case p.AddField != nil:
+		for k, v := range p.AddField {
+			fields[k] = v
+		}
+	case p.Redact != nil:
+		e.Text = p.Redact.redact(e.Text)
+		for k, v := range fields {
+			fields[k] = p.Redact.redact(v)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	var conf FileConfig
+	err := json.Unmarshal([]byte(`{"processors": [
+		{"rename": {"lvl": "level", "level": "old_level"}},
+		{"lowercase": ["level", "missing"]},
+		{"drop_fields": ["password"]},
+		{"add_field": {"pipeline": "app"}}
+	]}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{"lvl": "WARN", "level": "x", "password": "hunter2"}
+	for i := range conf.Processors {
+		conf.Processors[i].apply(&FileEvent{Fields: fields})
+	}
+	expected := map[string]string{"level": "warn", "old_level": "x", "pipeline": "app"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected %v, got %v", expected, fields)
+	}
+
+	for _, bad := range []string{`[{}]`, `[{"drop_fields": ["a"], "lowercase": ["b"]}]`} {
+		var p []processor
+		if err := json.Unmarshal([]byte(bad), &p); err == nil {
+			t.Fatalf("expected an error for processors %s", bad)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var p []processor
+	err := json.Unmarshal([]byte(`[{"redact": {"patterns": {
+		"card": "\\b\\d{4}-?\\d{4}-?\\d{4}-?\\d{4}\\b",
+		"token": "token=(\\S+)"
+	}}}]`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &FileEvent{
+		Text:   "paid with 4111-1111-1111-1111 token=abc123 ok",
+		Fields: map[string]string{"card": "4111111111111111", "user": "bob"},
+	}
+	p[0].apply(e)
+	if e.Text != "paid with ******************* token=****** ok" {
+		t.Errorf("unexpected text %q", e.Text)
+	}
+	if e.Fields["card"] != "****************" || e.Fields["user"] != "bob" {
+		t.Errorf("unexpected fields %v", e.Fields)
+	}
+
+	err = json.Unmarshal([]byte(`[{"redact": {"patterns": {"token": "token=(\\S+)"}, "hash": true, "hash_key": "k"}}]`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := &FileEvent{Text: "token=abc"}, &FileEvent{Text: "again token=abc"}
+	p[0].apply(a)
+	p[0].apply(b)
+	if !strings.HasPrefix(a.Text, "token=[token:") || strings.Contains(a.Text, "abc") || b.Text != "again "+a.Text {
+		t.Errorf("expected the same hash for the same value, got %q and %q", a.Text, b.Text)
+	}
+
+	for _, bad := range []string{
+		`[{"redact": {"patterns": {}}}]`,
+		`[{"redact": {"patterns": {"x": "("}}}]`,
+		`[{"redact": {"patterns": {"x": "y"}, "hash": true}}]`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &p); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestTransformPlugin(t *testing.T) {
+	p := &transformPlugin{path: "test", transform: func(text string, fields map[string]string) (string, map[string]string, bool) {
+		if text == "drop" {
+			return "", nil, false
+		}
+		if text == "panic" {
+			panic("oops")
+		}
+		fields["length"] = strconv.Itoa(len(text))
+		return strings.ToUpper(text), fields, true
+	}}
+	e := &FileEvent{Text: "hello", Fields: map[string]string{"a": "b"}}
+	if !p.apply(e) || e.Text != "HELLO" || e.Fields["length"] != "5" || e.Fields["a"] != "b" {
+		t.Errorf("unexpected transformed event %+v", e)
+	}
+	if p.apply(&FileEvent{Text: "drop", Fields: map[string]string{}}) {
+		t.Errorf("expected the event to be dropped")
+	}
+	e = &FileEvent{Text: "panic", Fields: map[string]string{}}
+	if !p.apply(e) || e.Text != "panic" {
+		t.Errorf("expected the event to be shipped as is after a panic, got %+v", e)
+	}
+
+	var conf FileConfig
+	if err := json.Unmarshal([]byte(`{"transform_plugin": "/nonexistent.so"}`), &conf); err == nil {
+		t.Errorf("expected an error loading a missing plugin")
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"log"
 	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// time between scans of a prospector's paths.  Set by -scan-interval.
+var prospectInterval = 10 * time.Second
+
 // finds files in paths/globs to harvest, starts harvesters
 func Prospect(fileconfig FileConfig, netconf NetworkConfig) {
 	out := netconf.EventChan(fileconfig.Dest)
@@ -14,38 +20,82 @@ func Prospect(fileconfig FileConfig, netconf NetworkConfig) {
 		log.Printf("ERROR unable to start prospector for %v: no event channel", fileconfig.Paths)
 		return
 	}
+	if fileconfig.MaxBytesPerSecond > 0 {
+		fileconfig.readLimit = newRateLimiter(fileconfig.MaxBytesPerSecond)
+	}
+	if fileconfig.ErrorDest != "" {
+		fileconfig.errorOut = netconf.EventChan(fileconfig.ErrorDest)
+	}
+	if fileconfig.EmitBatchSize > 1 {
+		fileconfig.batchOut = netconf.BatchChan(fileconfig.Dest)
+	}
+
+	if fileconfig.UnixSocket != "" {
+		if err := harvestUnixSocket(&fileconfig, out); err != nil {
+			log.Printf("ERROR socket prospector stopping: %v", err)
+		}
+		return
+	}
+	if fileconfig.SyslogListen != "" {
+		if err := harvestSyslog(&fileconfig, out); err != nil {
+			log.Printf("ERROR syslog prospector stopping: %v", err)
+		}
+		return
+	}
 
 	// Handle any "-" (stdin) paths
 	for i, path := range fileconfig.Paths {
 		if path == "-" {
-			harvester := Harvester{
-				Path:   path,
-				Fields: fileconfig.Fields,
-				join:   fileconfig.Join,
-				out:    out,
-			}
-			go harvester.Harvest(0, 0)
+			harvester := newHarvester(path, &fileconfig, out)
+			startHarvester(harvester, 0, 0)
 
 			// Remove it from the file list
 			fileconfig.Paths = append(fileconfig.Paths[:i], fileconfig.Paths[i+1:]...)
 		}
 	}
 
+	addProspectorConf(&fileconfig)
+
 	// Use the registrar db to reopen any files at their last positions
 	fileinfo := make(map[string]os.FileInfo)
-	resume_tracking(fileconfig, fileinfo, out)
+	resume_tracking(&fileconfig, fileinfo, out)
 
+	name := strings.Join(fileconfig.Paths, ",")
+	empty := make(map[string]time.Time) // when each empty path was last warned about
 	for {
 		for _, path := range fileconfig.Paths {
-			prospector_scan(path, &fileconfig, fileinfo, out)
+			n := prospector_scan(path, &fileconfig, fileinfo, out)
+			warnEmptyPath(path, n, empty)
 		}
+		healthScanned(name)
 
 		// Defer next scan for a bit.
-		time.Sleep(10 * time.Second) // Make this tunable
+		time.Sleep(prospectInterval)
 	}
 } /* Prospect */
 
-func resume_tracking(fileconfig FileConfig, fileinfo map[string]os.FileInfo, output chan *FileEvent) {
+// how often to repeat the warning about a path that matches no files.
+const emptyPathWarnInterval = time.Hour
+
+// warns about a path that matches no files, as it's most likely wrong, on the
+// first scan and then every emptyPathWarnInterval for as long as it stays
+// that way.
+func warnEmptyPath(path string, matches int, warned map[string]time.Time) {
+	last, ok := warned[path]
+	if matches > 0 {
+		if ok {
+			log.Printf("path %s now matches %d files", path, matches)
+			delete(warned, path)
+		}
+		return
+	}
+	if !ok || time.Since(last) >= emptyPathWarnInterval {
+		log.Printf("WARNING path %s matches no files; is it right?", path)
+		warned[path] = time.Now()
+	}
+}
+
+func resume_tracking(fileconfig *FileConfig, fileinfo map[string]os.FileInfo, output chan *FileEvent) {
 	var p progress
 	if err := p.load(options.HistoryPath); err != nil {
 		log.Printf("unable to load lumberjack progress file: %s", err.Error())
@@ -53,31 +103,35 @@ func resume_tracking(fileconfig FileConfig, fileinfo map[string]os.FileInfo, out
 	}
 
 	for path, state := range p {
-		info, err := os.Stat(path)
+		seedGeneration(path, state.Generation)
+		info, err := statFile(path)
 		if err != nil {
 			log.Printf("unable to stat file in resume_tracking: %s", err.Error())
 			continue
 		}
+		if info.IsDir() {
+			log.Printf("WARNING not resuming %s: it's a directory", path)
+			continue
+		}
 
 		if is_file_same(path, info, state) {
 			// same file, seek to last known position
 			fileinfo[path] = info
 
 			for _, pathglob := range fileconfig.Paths {
-				match, err := filepath.Match(pathglob, path)
-				if err != nil {
-					log.Printf("error matching file path: %s", err.Error())
-					continue
-				}
-				if match {
-					log.Printf("resume tracking %s", path)
-					harvester := Harvester{
-						Path:   path,
-						Fields: fileconfig.Fields,
-						join:   fileconfig.Join,
-						out:    output,
+				if matchPath(pathglob, path, fileconfig.MaxDepth) {
+					harvester := newHarvester(path, fileconfig, output)
+					if !state.contentMatches(path) {
+						// the inode has been reused by a different file.
+						log.Printf("WARNING not resuming %s: its contents before offset %d have changed", path, state.Offset)
+						startHarvester(harvester, 0, 0)
+					} else if state.Completed {
+						log.Printf("not resuming %s: it was harvested to completion", path)
+					} else {
+						log.Printf("resume tracking %s", path)
+						harvester.resumed = true
+						startHarvester(harvester, state.Offset, 0)
 					}
-					go harvester.Harvest(state.Offset, 0)
 					break
 				}
 			}
@@ -85,32 +139,43 @@ func resume_tracking(fileconfig FileConfig, fileinfo map[string]os.FileInfo, out
 	}
 }
 
+// checks the files matching path, starting harvesters for any that are new or
+// have been rotated, and returns how many files matched.
 func prospector_scan(path string, conf *FileConfig,
 	fileinfo map[string]os.FileInfo,
-	output chan *FileEvent) {
+	output chan *FileEvent) int {
 
 	// Evaluate the path as a wildcards/shell glob
-	matches, err := filepath.Glob(path)
+	matches, matched, err := conf.glob(path)
 	if err != nil {
 		log.Printf("glob(%s) failed: %v\n", path, err)
-		return
+		return 0
 	}
 
 	// If the glob matches nothing, use the path itself as a literal.
 	if len(matches) == 0 && path == "-" {
 		matches = append(matches, path)
+		matched = 1
+	}
+
+	if conf.RotationRank != nil {
+		matches = byRank(matches, *conf.RotationRank)
 	}
 
+	// new files, in the order their harvesters should be started in when
+	// backfilling in order.
+	var backfill []string
+
 	// Check any matched files to see if we need to start a harvester
 	for _, file := range matches {
-		info, err := os.Stat(file)
+		info, err := statFile(file)
 		if err != nil {
 			log.Printf("prospector unable to stat file %s: %s\n", file, err)
 			continue
 		}
 
 		if info.IsDir() {
-			log.Printf("prospector skipping directory: %s\n", file)
+			log.Printf("WARNING prospector skipping %s, matched by %s: it's a directory\n", file, path)
 			continue
 		}
 
@@ -129,26 +194,169 @@ func prospector_scan(path string, conf *FileConfig,
 			if time.Since(info.ModTime()) > 24*time.Hour {
 				log.Printf("skipping old file: %s\n", file)
 			} else if is_file_renamed(file, info, fileinfo) {
-				// Check to see if this file was simply renamed (known inode+dev)
+				// Check to see if this file was simply renamed (known
+				// inode+dev).  If its harvester had timed out, anything
+				// written to it before it was renamed still needs reading.
+				if offset, ok := registry.grown(info); ok {
+					log.Printf("harvest grown renamed file: %s from %d\n", file, offset)
+					harvester := newHarvester(file, conf, output)
+					startHarvester(harvester, offset, h_Rewind)
+				}
+			} else if (conf.OrderedBackfill || conf.BackfillConcurrency > 0) && conf.fromBeginning() {
+				backfill = append(backfill, file)
 			} else {
 				log.Printf("harvest new file: %s\n", file)
-				harvester := Harvester{
-					Path:   file,
-					Fields: conf.Fields,
-					join:   conf.Join,
-					out:    output,
-				}
-				go harvester.Harvest(0, 0)
+				harvester := newHarvester(file, conf, output)
+				startHarvester(harvester, 0, 0)
 			}
 		} else if !is_fileinfo_same(lastinfo, info) {
 			log.Printf("harvest rotated file: %s\n", file)
-			harvester := Harvester{
-				Path:   file,
-				Fields: conf.Fields,
-				join:   conf.Join,
-				out:    output,
-			}
-			go harvester.Harvest(0, h_Rewind)
+			nextGeneration(file)
+			harvester := newHarvester(file, conf, output)
+			startHarvester(harvester, 0, h_Rewind)
+		} else if offset, ok := registry.grown(info); ok {
+			// its harvester timed out, and it's been written to since.
+			// Carry on from where that left off, not from the end.
+			log.Printf("harvest grown file: %s from %d\n", file, offset)
+			harvester := newHarvester(file, conf, output)
+			startHarvester(harvester, offset, h_Rewind)
+		} else if conf.ActiveWindow > 0 {
+			closeInactive(file, info, time.Duration(conf.ActiveWindow)*time.Second)
 		}
 	} // for each file matched by the glob
+
+	if len(backfill) > 0 {
+		if conf.OrderedBackfill {
+			sortByRotation(backfill, fileinfo)
+		}
+		if conf.backfillSlots == nil {
+			slots := conf.BackfillConcurrency
+			if slots < 1 {
+				slots = 1
+			}
+			conf.backfillSlots = make(chan struct{}, slots)
+		}
+		backfillStat.Add("files_waiting", int64(len(backfill)))
+		go harvestInOrder(backfill, conf, output)
+	}
+	return matched
+}
+
+// asks the harvester of a file that hasn't been modified within window to
+// stop, once it has read to the end, so that only the files that are
+// actually being written to are held open.  Its position is kept, and when
+// the file is written to again it's picked up as a grown file.
+func closeInactive(file string, info os.FileInfo, window time.Duration) {
+	if time.Since(info.ModTime()) <= window {
+		return
+	}
+	h := registry.byPath(file)
+	if h == nil || h.fi == nil || !is_fileinfo_same(h.fi, info) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&h.inactive, 0, 1) {
+		log.Printf("%s not modified in %v, closing it once it's read", file, window)
+	}
+}
+
+// harvests files in order, as many at a time as there are backfill slots: each
+// harvester is started once a slot is free, and frees it when it has read to
+// the end of its file and is just tailing it.  The slots are shared by every
+// scan of the prospector, so files found later wait for earlier ones.
+func harvestInOrder(files []string, conf *FileConfig, output chan *FileEvent) {
+	for _, file := range files {
+		conf.backfillSlots <- struct{}{}
+		backfillStat.Add("files_waiting", -1)
+		backfillStat.Add("files_reading", 1)
+		log.Printf("harvest new file in order: %s\n", file)
+		harvester := newHarvester(file, conf, output)
+		drained := make(chan struct{})
+		harvester.drained = drained
+		startHarvester(harvester, 0, 0)
+		go func() {
+			<-drained
+			backfillStat.Add("files_reading", -1)
+			backfillStat.Add("files_done", 1)
+			<-conf.backfillSlots
+		}()
+	}
+}
+
+// sorts paths oldest first, assuming they're logrotate style rotations of
+// each other: app.log.2.gz, app.log.1, app.log.  Files with numeric rotation
+// suffixes sort by that suffix, largest first; everything else sorts by
+// modification time, and files without a rotation suffix come last.
+func sortByRotation(paths []string, fileinfo map[string]os.FileInfo) {
+	sort.Sort(byRotation{paths, fileinfo})
+}
+
+type byRotation struct {
+	paths    []string
+	fileinfo map[string]os.FileInfo
+}
+
+func (b byRotation) Len() int      { return len(b.paths) }
+func (b byRotation) Swap(i, j int) { b.paths[i], b.paths[j] = b.paths[j], b.paths[i] }
+
+func (b byRotation) Less(i, j int) bool {
+	ri, ni := rotationSuffix(b.paths[i])
+	rj, nj := rotationSuffix(b.paths[j])
+	if ri != rj {
+		return ri
+	}
+	if ni != nj {
+		return ni > nj
+	}
+	fi, fj := b.fileinfo[b.paths[i]], b.fileinfo[b.paths[j]]
+	if fi != nil && fj != nil && !fi.ModTime().Equal(fj.ModTime()) {
+		return fi.ModTime().Before(fj.ModTime())
+	}
+	return b.paths[i] < b.paths[j]
+}
+
+// picks, for each base name among paths, the file at the given rank among its
+// rotations, newest first: app.log is rank 0, app.log.1 rank 1, and so on.
+// Base names with fewer rotations than that are left out.
+func byRank(paths []string, rank int) []string {
+	bases := make(map[string][]string)
+	var order []string
+	for _, path := range paths {
+		base, _ := lrStrip(strings.TrimSuffix(path, ".gz"))
+		if _, ok := bases[base]; !ok {
+			order = append(order, base)
+		}
+		bases[base] = append(bases[base], path)
+	}
+
+	var picked []string
+	for _, base := range order {
+		rotations := bases[base]
+		if rank >= len(rotations) {
+			continue
+		}
+		fileinfo := make(map[string]os.FileInfo, len(rotations))
+		for _, path := range rotations {
+			if info, err := statFile(path); err == nil {
+				fileinfo[path] = info
+			}
+		}
+		sortByRotation(rotations, fileinfo)
+		picked = append(picked, rotations[len(rotations)-1-rank])
+	}
+	return picked
+}
+
+// reports whether path has a logrotate suffix, and the number in it if the
+// suffix is numeric.  A trailing compression extension is ignored.
+func rotationSuffix(path string) (bool, int) {
+	path = strings.TrimSuffix(path, ".gz")
+	stripped, ok := lrStrip(path)
+	if !ok {
+		return false, 0
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(path[len(stripped):], "."))
+	if err != nil {
+		return true, 0
+	}
+	return true, n
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortByRotation(t *testing.T) {
+	paths := []string{"app.log", "app.log.1", "app.log.10", "app.log.2.gz"}
+	sortByRotation(paths, make(map[string]os.FileInfo))
+	expected := []string{"app.log.10", "app.log.2.gz", "app.log.1", "app.log"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
+// a glob that matches a directory as well as a file skips the directory, and
+// carries on with the file.
+func TestProspectorSkipsDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, sub := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	if err := ioutil.WriteFile(file, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// old enough that no harvester is started for it.
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	fileinfo := make(map[string]os.FileInfo)
+	prospector_scan(filepath.Join(dir, "*.log"), &FileConfig{}, fileinfo, make(chan *FileEvent))
+	if _, ok := fileinfo[sub]; ok {
+		t.Fatalf("directory %s was treated as a file", sub)
+	}
+	if _, ok := fileinfo[file]; !ok {
+		t.Fatalf("file %s was skipped along with the directory", file)
+	}
+}
+
+// a file's harvester times out, and then the file grows.  The prospector must
+// start a new harvester from where the old one stopped, so that every line
+// appended is shipped once.
+func TestProspectorGrownFile(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+	defer endHarvesters()
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	h.readlines(50 * time.Millisecond)
+	h.file.Close()
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("two\nthree\n")
+	w.Close()
+
+	before := reopenBytesStat.Value()
+	fileinfo := map[string]os.FileInfo{h.Path: h.fi}
+	prospector_scan(h.Path, &FileConfig{}, fileinfo, out)
+	for _, expected := range []string{"two", "three"} {
+		select {
+		case e := <-out:
+			if e.Text != expected {
+				t.Fatalf("expected %q, got %q", expected, e.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+	if n := reopenBytesStat.Value() - before; n != 10 {
+		t.Errorf("expected 10 bytes counted on reopen, got %d", n)
+	}
+
+	// scanning again mustn't start another harvester.
+	prospector_scan(h.Path, &FileConfig{}, fileinfo, out)
+	select {
+	case e := <-out:
+		t.Fatalf("unexpected event %q", e.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// with one backfill slot, newly found files are read one at a time: the
+// second isn't started until the first has been read to the end.
+func TestProspectorBackfillConcurrency(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+	testRegistry()
+	defer endHarvesters()
+
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.log"), []byte("a1\na2\na3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.log"), []byte("b1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := backfillStat.Get("files_done")
+	before := int64(0)
+	if done != nil {
+		before = done.(*expvar.Int).Value()
+	}
+	fromBeginning := true
+	conf := &FileConfig{FromBeginning: &fromBeginning, BackfillConcurrency: 1}
+	out := make(chan *FileEvent, 16)
+	prospector_scan(filepath.Join(dir, "*.log"), conf, make(map[string]os.FileInfo), out)
+	for _, expected := range []string{"a1", "a2", "a3", "b1"} {
+		select {
+		case e := <-out:
+			if e.Text != expected {
+				t.Fatalf("expected %q, got %q", expected, e.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for backfillStat.Get("files_done").(*expvar.Int).Value()-before != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 files backfilled, stats are %v", backfillStat)
+		}
+		time.Sleep(eofPoll)
+	}
+}
+
+func TestByRank(t *testing.T) {
+	paths := []string{"app.log", "app.log.1", "app.log.2.gz", "app.log.3.gz", "other.log", "other.log.1"}
+	for rank, expected := range [][]string{
+		{"app.log", "other.log"},
+		{"app.log.1", "other.log.1"},
+		{"app.log.2.gz"},
+		{"app.log.3.gz"},
+		nil,
+	} {
+		if picked := byRank(paths, rank); !reflect.DeepEqual(picked, expected) {
+			t.Errorf("rank %d: expected %v, got %v", rank, expected, picked)
+		}
+	}
+}
+
+func TestWarnEmptyPath(t *testing.T) {
+	warned := make(map[string]time.Time)
+	warnEmptyPath("/nope/*.log", 0, warned)
+	first, ok := warned["/nope/*.log"]
+	if !ok {
+		t.Fatal("expected an empty path to be warned about")
+	}
+	warnEmptyPath("/nope/*.log", 0, warned)
+	if !warned["/nope/*.log"].Equal(first) {
+		t.Fatal("expected the warning not to be repeated straight away")
+	}
+	warnEmptyPath("/nope/*.log", 1, warned)
+	if _, ok := warned["/nope/*.log"]; ok {
+		t.Fatal("expected a path that matches files to be forgotten")
+	}
+}
+
+// a file that hasn't been modified within the active window has its harvester
+// closed, and is picked up again from where it stopped once it's written to.
+func TestProspectorActiveWindow(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	done := startReadlines(h, time.Minute)
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(h.Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	conf := &FileConfig{ActiveWindow: 60}
+	fileinfo := map[string]os.FileInfo{h.Path: h.fi}
+	prospector_scan(h.Path, conf, fileinfo, out)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("harvester of the inactive file wasn't closed")
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("two\n")
+	w.Close()
+	prospector_scan(h.Path, conf, fileinfo, out)
+	select {
+	case e := <-out:
+		if e.Text != "two" {
+			t.Fatalf("expected two, got %q", e.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for two")
+	}
+}
+
+// a file resumed from the progress file carries on where it was, and with
+// resume_field, the first event says so.
+func TestResumeTrackingResumeField(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+	testRegistry()
+	defer endHarvesters()
+
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino, dev := file_ids(info)
+	p := progress{path: &FileState{Source: path, Offset: 4, Inode: ino, Device: dev}}
+	defer func(path string) { options.HistoryPath = path }(options.HistoryPath)
+	options.HistoryPath = filepath.Join(dir, ".lumberjack")
+	if err := p.writeFile(options.HistoryPath); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan *FileEvent, 16)
+	conf := &FileConfig{Paths: []string{path}, ResumeField: true}
+	resume_tracking(conf, make(map[string]os.FileInfo), out)
+	for _, expected := range []string{"two", "three"} {
+		select {
+		case e := <-out:
+			if e.Text != expected {
+				t.Fatalf("expected %q, got %q", expected, e.Text)
+			}
+			if resumed := e.Fields["resumed"]; (expected == "two") != (resumed == "true") {
+				t.Fatalf("%s: unexpected resumed field %q", expected, resumed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
+
+// a file's harvester times out, the file is written to, and then rotated
+// away before the next scan.  What was written before the rotation is still
+// shipped.
+func TestProspectorGrownRenamedFile(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	h.readlines(50 * time.Millisecond)
+	h.file.Close()
+	if e := <-out; e.Text != "one" {
+		t.Fatalf("expected one, got %q", e.Text)
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("two\n")
+	w.Close()
+	rotated := h.Path + ".1"
+	if err := os.Rename(h.Path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotated)
+
+	defer endHarvesters()
+	fileinfo := map[string]os.FileInfo{h.Path: h.fi}
+	prospector_scan(h.Path+"*", &FileConfig{}, fileinfo, out)
+	select {
+	case e := <-out:
+		if e.Text != "two" || e.Source != rotated {
+			t.Fatalf("expected two from %s, got %q from %s", rotated, e.Text, e.Source)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lines written before the rotation weren't shipped")
+	}
+}
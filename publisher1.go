@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/binary"
+	"expvar"
 	"fmt"
 	"log"
 	"math/rand"
@@ -25,8 +26,54 @@ type Publisher struct {
 	socket    *tls.Conn     // currently active connection. may be nil.
 	sequence  uint32        // incremental event id for current connection.
 	addr      string        // tcp address to connect to
+	name      string        // addr, and which of its workers this is if there's more than one
 	tlsConfig tls.Config    // tls config to use for establishing secure connection
 	timeout   time.Duration // send timeout
+
+	serialization string // how events are written.  See serializeKV.
+
+	maxRetries int    // attempts at sending a page before giving up on it. 0 means retry forever.
+	deadLetter string // file that pages we've given up on are written to
+	lastErr    error  // the most recent error sending a page
+
+	maxEventAge time.Duration // how long after being read events are dropped unsent. 0 means never.
+
+	peers       *publisherPeers // the other publishers in our network group
+	slowAck     time.Duration   // average ack latency above which we're slow. 0 disables.
+	slowPeriod  time.Duration   // how long we have to be slow for before stepping back
+	latency     time.Duration   // average time for a page to be acknowledged
+	latencyStat *expvar.Int
+	slowSince   time.Time // when latency went above slowAck
+
+	stats *publisherStats
+
+	// pages the group's publishers have failed to send.  See failedPage.
+	retries chan failedPage
+
+	faults    *faultConfig // injected into connections, if enabled
+	faultRand *rand.Rand
+}
+
+// counts of what each publisher has done, in the publishers expvar, by
+// publisher name.
+var publishersStat = expvar.NewMap("publishers")
+
+type publisherStats struct {
+	pages  *expvar.Int // pages acknowledged
+	events *expvar.Int // events in those pages
+	errors *expvar.Int // failures sending a page or reading its ack
+	stale  *expvar.Int // events dropped by max_event_age
+}
+
+func newPublisherStats(name string) *publisherStats {
+	s := &publisherStats{new(expvar.Int), new(expvar.Int), new(expvar.Int), new(expvar.Int)}
+	m := new(expvar.Map).Init()
+	m.Set("pages", s.pages)
+	m.Set("events", s.events)
+	m.Set("errors", s.errors)
+	m.Set("stale", s.stale)
+	publishersStat.Set(name, m)
+	return s
 }
 
 func (p *Publisher) publish(input chan eventPage, registrar chan eventPage) {
@@ -43,19 +90,32 @@ func (p *Publisher) publish(input chan eventPage, registrar chan eventPage) {
 	}
 
 SENDING:
-	for page := range input {
-		if err := page.compress(p.sequence, &p.buffer); err != nil {
-			log.Println(err)
-			//  if we hit this, we've lost log lines.  This is potentially
-			//  fatal and should alert a human.
+	for {
+		p.yieldIfSlow()
+		page, attempts, ok := p.next(input)
+		if !ok {
+			break
+		}
+		if attempts > 0 && p.giveUp(page, attempts) {
+			registrar <- page
+			continue
+		}
+		if page = p.dropStale(page, registrar); len(page) == 0 {
+			continue
+		}
+		if err := page.compress(p.sequence, &p.buffer, p.serialization); err != nil {
+			p.uncompressable(page, err, registrar)
 			continue
 		}
 		p.sequence += uint32(len(page))
 		compressed_payload := p.buffer.Bytes()
 
-	SENDPAYLOAD:
+		waitWhilePaused()
+		attempts++
+		sendStart := time.Now()
 		if err := p.sendPayload(len(page), compressed_payload); err != nil {
-			input <- page
+			p.stats.errors.Add(1)
+			p.retry(page, attempts, err)
 			sleep := time.Duration(1e9 + rand.Intn(1e10))
 			log.Printf("Socket error, will reconnect in %v: %s\n", sleep, err)
 			time.Sleep(sleep)
@@ -72,6 +132,8 @@ SENDING:
 		for ackbytes != 6 {
 			n, err := p.socket.Read(response)
 			if err != nil {
+				p.stats.errors.Add(1)
+				p.retry(page, attempts, err)
 				log.Printf("Read error after %d bytes looking for ack: %s\n", n, err)
 				log.Println("page will be re-sent")
 				log.Println("closing socket to %s", p.addr)
@@ -81,21 +143,90 @@ SENDING:
 					log.Printf("publisher closed connection to %s\n", p.addr)
 				}
 				p.connect()
-				goto SENDPAYLOAD
+				continue SENDING
 			} else {
 				ackbytes += n
 			}
 		}
 
 		// TODO(sissel): verify ack
+		p.recordAckLatency(time.Since(sendStart))
 
 		// Tell the registrar that we've successfully sent these events
-		log.Printf("publisher %d sent %d events to %s", p.id, len(page), p.addr)
+		log.Printf("publisher %d sent %d events to %s", p.id, len(page), p.name)
+		p.stats.pages.Add(1)
+		p.stats.events.Add(int64(len(page)))
 		registrar <- page
 	} /* for each event payload */
 
 }
 
+// a page that failed to send, handed back to its network group so that any
+// of the group's publishers can try it again.
+type failedPage struct {
+	page     eventPage
+	attempts int   // tries so far, counted only with max_retries
+	err      error // why the last try failed
+}
+
+// the next page to send, and how many times it's been tried.  Pages the group
+// has failed to send come before new ones.
+func (p *Publisher) next(input chan eventPage) (eventPage, int, bool) {
+	select {
+	case f := <-p.retries:
+		p.lastErr = f.err
+		return f.page, f.attempts, true
+	default:
+	}
+	select {
+	case f := <-p.retries:
+		p.lastErr = f.err
+		return f.page, f.attempts, true
+	case page, ok := <-input:
+		return page, 0, ok
+	}
+}
+
+// hands a page that failed to send back to the group, so that another of
+// its publishers, connected to another server, can send it while we
+// reconnect.
+func (p *Publisher) retry(page eventPage, attempts int, err error) {
+	p.lastErr = err
+	if p.maxRetries <= 0 {
+		attempts = 0
+	}
+	p.retries <- failedPage{page, attempts, err}
+}
+
+// checks whether a page has been attempted too many times.  If it has, the
+// page is written to the dead letter file, and true is returned: the page
+// should be passed on to the registrar as though it were sent, so that a
+// single poison page can't block every page behind it.
+func (p *Publisher) giveUp(page eventPage, attempts int) bool {
+	if p.maxRetries <= 0 || attempts <= p.maxRetries {
+		return false
+	}
+	log.Printf("ERROR publisher %d giving up on %d events after %d attempts: %v",
+		p.id, len(page), attempts, p.lastErr)
+	if err := writeDeadLetter(p.deadLetter, page, p.lastErr); err != nil {
+		log.Printf("ERROR unable to write dead letter file, %d events lost: %v", len(page), err)
+	}
+	return true
+}
+
+// passes on a page that can't be compressed.  Trying again won't help, so
+// it's written to the dead letter file and passed on to the registrar, as
+// giveUp's pages are, so that the positions of its files still move on.
+func (p *Publisher) uncompressable(page eventPage, err error, registrar chan eventPage) {
+	//  if we hit this, we've lost log lines.  This is potentially
+	//  fatal and should alert a human.
+	log.Printf("ERROR publisher %d unable to send %d events: %v", p.id, len(page), err)
+	if err := writeDeadLetter(p.deadLetter, page, err); err != nil {
+		log.Printf("ERROR unable to write dead letter file, %d events lost: %v", len(page), err)
+	}
+	registrar <- page
+}
+
 func (p *Publisher) sendPayload(size int, payload []byte) error {
 	if err := p.socket.SetDeadline(time.Now().Add(p.timeout)); err != nil {
 		return fmt.Errorf("unable to set deadline in sendPayload: %v", err)
@@ -116,6 +247,7 @@ func (p *Publisher) sendPayload(size int, payload []byte) error {
 }
 
 func (p *Publisher) connect() {
+	healthConnected(p.id, false)
 	for {
 		sock, err := net.DialTimeout("tcp", p.addr, p.timeout)
 		if err != nil {
@@ -125,6 +257,9 @@ func (p *Publisher) connect() {
 			time.Sleep(sleep)
 			continue
 		}
+		if p.faults.enabled() {
+			sock = &faultConn{Conn: sock, faults: p.faults, rand: p.faultRand}
+		}
 		p.socket = tls.Client(sock, &p.tlsConfig)
 		if err := p.socket.SetDeadline(time.Now().Add(p.timeout)); err != nil {
 			log.Printf("unable to set deadline in connect: %v\n", err)
@@ -142,6 +277,7 @@ func (p *Publisher) connect() {
 			continue
 		}
 		log.Printf("Publisher %v connected to %s\n", p.id, p.addr)
+		healthConnected(p.id, true)
 		return
 	}
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a logstash server for publishers to send to.  Each page is acknowledged
+// after delay(conn), where conn counts the connections made to it from 0.
+type fakeServer struct {
+	addr     string
+	listener net.Listener
+
+	sync.Mutex
+	received []time.Time // when each page arrived
+}
+
+func newFakeServer(t *testing.T, delay func(conn int) time.Duration) *fakeServer {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCert(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{addr: l.Addr().String(), listener: l}
+	go func() {
+		for conn := 0; ; conn++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, delay(conn))
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn, delay time.Duration) {
+	defer c.Close()
+	var header [6]byte
+	for {
+		if _, err := io.ReadFull(c, header[:]); err != nil {
+			return
+		}
+		if string(header[:2]) == "1W" {
+			continue
+		}
+		if _, err := io.CopyN(ioutil.Discard, c, int64(binary.BigEndian.Uint32(header[2:]))); err != nil {
+			return
+		}
+		s.Lock()
+		s.received = append(s.received, time.Now())
+		s.Unlock()
+		time.Sleep(delay)
+		if _, err := c.Write([]byte("1A\x00\x00\x00\x00")); err != nil {
+			return
+		}
+	}
+}
+
+// the pages received between from and to.
+func (s *fakeServer) pagesBetween(from, to time.Time) int {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for _, at := range s.received {
+		if !at.Before(from) && at.Before(to) {
+			n++
+		}
+	}
+	return n
+}
+
+// a publisher for server, as startPublishers makes them.
+func testPublisher(id int, server *fakeServer, peers *publisherPeers, retries chan failedPage) *Publisher {
+	name := fmt.Sprintf("%s#%d", server.addr, id)
+	p := &Publisher{
+		id:        id,
+		sequence:  1,
+		addr:      server.addr,
+		name:      name,
+		tlsConfig: tls.Config{ServerName: "127.0.0.1", RootCAs: testCertPool},
+		timeout:   5 * time.Second,
+		peers:     peers,
+		retries:   retries,
+		stats:     newPublisherStats(name),
+	}
+	peers.setSlow(id, false)
+	return p
+}
+
+// runs publishers until input is closed, and then waits for them to stop.
+func runPublishers(publishers []*Publisher, input, registrar chan eventPage) (wait func()) {
+	var wg sync.WaitGroup
+	for _, p := range publishers {
+		wg.Add(1)
+		go func(p *Publisher) {
+			defer wg.Done()
+			p.publish(input, registrar)
+		}(p)
+	}
+	return wg.Wait
+}
+
+var (
+	testCertOnce sync.Once
+	testCertPair tls.Certificate
+	testCertPool *x509.CertPool
+)
+
+// a self-signed certificate for 127.0.0.1, which testCertPool trusts.
+func testCert(t *testing.T) tls.Certificate {
+	testCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCertPair = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+		testCertPool = x509.NewCertPool()
+		testCertPool.AddCert(cert)
+	})
+	return testCertPair
+}
+
+// a page that fails to send goes back to the group, ahead of new pages, for
+// whichever publisher is free.
+func TestPublisherRetriesFailover(t *testing.T) {
+	retries := make(chan failedPage, 2)
+	input := make(chan eventPage, 1)
+	failing := &Publisher{retries: retries}
+	other := &Publisher{retries: retries}
+
+	failed := eventPage{&FileEvent{Text: "failed"}}
+	input <- eventPage{&FileEvent{Text: "new"}}
+	failing.retry(failed, 3, errors.New("connection refused"))
+
+	page, attempts, ok := other.next(input)
+	if !ok || page[0].Text != "failed" {
+		t.Fatalf("expected the failed page first, got %v", page)
+	}
+	if attempts != 0 {
+		t.Fatalf("expected attempts not to be counted without max_retries, got %d", attempts)
+	}
+	if page, _, _ := other.next(input); page[0].Text != "new" {
+		t.Fatalf("expected the new page next, got %v", page)
+	}
+}
+
+// with max_retries, the attempts made by every publisher count.
+func TestPublisherRetriesCounted(t *testing.T) {
+	retries := make(chan failedPage, 2)
+	failing := &Publisher{retries: retries, maxRetries: 5}
+	other := &Publisher{retries: retries, maxRetries: 5}
+
+	failing.retry(eventPage{&FileEvent{Text: "failed"}}, 3, errors.New("timeout"))
+	_, attempts, _ := other.next(make(chan eventPage))
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts carried over, got %d", attempts)
+	}
+	if other.lastErr == nil || other.lastErr.Error() != "timeout" {
+		t.Fatalf("expected the last error to be carried over, got %v", other.lastErr)
+	}
+}
+
+// a page that can't be compressed is dead lettered and passed on to the
+// registrar, so that its events don't stay waiting for an acknowledgement.
+func TestPublisherUncompressable(t *testing.T) {
+	f, err := ioutil.TempFile("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	p := &Publisher{deadLetter: f.Name()}
+	registrar := make(chan eventPage, 1)
+
+	p.uncompressable(eventPage{&FileEvent{Text: "lost"}}, errors.New("zlib broke"), registrar)
+	select {
+	case page := <-registrar:
+		if len(page) != 1 || page[0].Text != "lost" {
+			t.Fatalf("expected the page to be passed to the registrar, got %v", page)
+		}
+	default:
+		t.Fatal("expected the page to be passed to the registrar")
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"line":"lost"`) || !strings.Contains(string(b), "zlib broke") {
+		t.Fatalf("expected the page in the dead letter file, got %s", b)
+	}
+}
+
+// two workers share a server, one of them acknowledging slowly, so pages are
+// acknowledged out of order.  The position recorded for the file must never
+// move past an event that hasn't been acknowledged yet.
+func TestPublisherWorkersOutOfOrder(t *testing.T) {
+	defer testAcks()()
+	server := newFakeServer(t, func(conn int) time.Duration {
+		if conn == 0 {
+			return 100 * time.Millisecond
+		}
+		return 0
+	})
+	defer server.listener.Close()
+
+	peers := newPublisherPeers()
+	retries := make(chan failedPage, 2)
+	workers := []*Publisher{
+		testPublisher(1111, server, peers, retries),
+		testPublisher(1112, server, peers, retries),
+	}
+	input, registrar := make(chan eventPage), make(chan eventPage, 1024)
+	wait := runPublishers(workers, input, registrar)
+
+	const pages = 20
+	info := tempFileInfo(t)
+	go func() {
+		for i := int64(0); i < pages; i++ {
+			e := &FileEvent{Source: "/var/log/app.log", Offset: i * 10, length: 10, fileinfo: info}
+			acks.sent(e)
+			input <- eventPage{e}
+		}
+		close(input)
+	}()
+
+	acked := make(map[int64]bool)
+	var furthest, offset int64
+	outOfOrder := false
+	for n := 0; n < pages; n++ {
+		var page eventPage
+		select {
+		case page = <-registrar:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for page %d", n)
+		}
+		e := page[0]
+		acked[e.Offset] = true
+		if e.Offset < furthest {
+			outOfOrder = true
+		} else {
+			furthest = e.Offset
+		}
+		unacked := int64(0)
+		for acked[unacked] {
+			unacked += 10
+		}
+		offset = page.progress()["/var/log/app.log"].Offset
+		if offset > unacked {
+			t.Fatalf("recorded offset %d with the event at %d unacknowledged", offset, unacked)
+		}
+	}
+	wait()
+
+	if !outOfOrder {
+		t.Fatal("expected the workers to acknowledge pages out of order")
+	}
+	if offset != pages*10 {
+		t.Fatalf("expected offset %d once everything was acknowledged, got %d", pages*10, offset)
+	}
+}
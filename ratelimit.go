@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces reads to a number of bytes per second, shared by all the
+// harvesters of a prospector with max_bytes_per_second set, so that reading a
+// large backlog doesn't saturate the disk the application is writing to.  Up
+// to one second's worth of bytes can be read in a burst.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64 // bytes that can be read without waiting.  May be negative.
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// the most that should be read at once.
+func (l *rateLimiter) burst() int {
+	if l.rate < 1 {
+		return 1
+	}
+	return int(l.rate)
+}
+
+// accounts for n bytes having been read, sleeping for as long as it takes for
+// that to fit within the rate.
+func (l *rateLimiter) wait(n int) {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledReader reads from r no faster than limit allows.
+type throttledReader struct {
+	r     io.Reader
+	limit *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limit.burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.limit.wait(n)
+	}
+	return n, err
+}
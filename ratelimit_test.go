@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	// the first second's worth is read straight away, the rest at the rate.
+	limit := newRateLimiter(50000)
+	r := &throttledReader{bytes.NewReader(make([]byte, 75000)), limit}
+	start := time.Now()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 75000 {
+		t.Fatalf("expected 75000 bytes, got %d", len(b))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("read 75000 bytes at 50000 bytes a second in %v", elapsed)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"time"
+)
+
+// with adaptive_read_ahead, each harvester sizes its read buffer to how fast
+// its file is growing: a busy file is read in big chunks, so each read turns
+// into many lines, while a quiet file only holds on to a small buffer.  The
+// buffer is sized to hold about readAheadSpan's worth of data, at the
+// average rate the file has been read at recently.
+const (
+	minReadAhead  = 4096 // bufio's default
+	maxReadAhead  = 1 << 20
+	readAheadSpan = 100 * time.Millisecond
+)
+
+// a moving average of how fast a harvester reads its file.
+type readRate struct {
+	since time.Time // start of the current sample
+	bytes int64     // read since then
+	rate  float64   // bytes a second
+}
+
+// counts n bytes read, and returns true once a second, when the average has
+// been updated with the bytes read over that second.
+func (rr *readRate) add(n int, now time.Time) bool {
+	rr.bytes += int64(n)
+	if rr.since.IsZero() {
+		rr.since = now
+		return false
+	}
+	elapsed := now.Sub(rr.since)
+	if elapsed < time.Second {
+		return false
+	}
+	// weights the latest second heavily, so a file that's gone quiet gets a
+	// small buffer back within a few seconds.
+	rr.rate = 0.5*rr.rate + 0.5*float64(rr.bytes)/elapsed.Seconds()
+	rr.since, rr.bytes = now, 0
+	return true
+}
+
+// the buffer size for the current rate: a power of two between minReadAhead
+// and maxReadAhead.
+func (rr *readRate) size() int {
+	want := int(rr.rate * readAheadSpan.Seconds())
+	size := minReadAhead
+	for size < want && size < maxReadAhead {
+		size *= 2
+	}
+	return size
+}
+
+// counts n bytes read by the harvester, and returns the reader to carry on
+// with, which is r unless the buffer is due to be resized.  Sizes only
+// change by a factor of four or more, so a rate that wavers around a
+// boundary doesn't make the harvester keep swapping buffers.
+func (h *Harvester) adaptReadAhead(r *bufio.Reader, n int) *bufio.Reader {
+	if !h.readRate.add(n, h.clock.Now()) {
+		return r
+	}
+	size, current := h.readRate.size(), r.Size()
+	if size < current*4 && size*4 > current {
+		return r
+	}
+	// whatever's buffered has already been read from the file, so is read
+	// again from a copy before carrying on with the file.
+	buffered, _ := r.Peek(r.Buffered())
+	pending := append([]byte(nil), buffered...)
+	log.Printf("harvester for %s reading %d bytes at a time, was %d", h.Path, size, current)
+	return bufio.NewReaderSize(&prefixReader{pending, h.fileReader()}, size)
+}
+
+// reads prefix, and then from r.  Unlike io.MultiReader, it carries on
+// reading from r after r has returned io.EOF, as a file being tailed may
+// grow.
+type prefixReader struct {
+	prefix []byte
+	r      io.Reader
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if len(p.prefix) > 0 {
+		n := copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		return n, nil
+	}
+	return p.r.Read(b)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"testing"
+	"time"
+)
+
+// the read buffer grows while the file is busy, without losing what was
+// already buffered, and shrinks again once it's quiet.
+func TestAdaptReadAhead(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntwo\nthree\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	clock := newFakeClock()
+	h.clock = clock
+
+	r := bufio.NewReader(h.fileReader())
+	if line, err := r.ReadString('\n'); err != nil || line != "one\n" {
+		t.Fatalf("expected one, got %q, %v", line, err)
+	}
+	if r = h.adaptReadAhead(r, 0); r.Size() != minReadAhead {
+		t.Fatalf("expected to start at %d bytes, got %d", minReadAhead, r.Size())
+	}
+	clock.Sleep(time.Second)
+	if r = h.adaptReadAhead(r, 1<<20); r.Size() != 64<<10 {
+		t.Fatalf("expected 64KB at 512KB/s, got %d", r.Size())
+	}
+	for _, expected := range []string{"two\n", "three\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != expected {
+			t.Fatalf("expected %q after resizing, got %q, %v", expected, line, err)
+		}
+	}
+
+	w, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		clock.Sleep(time.Second)
+		r = h.adaptReadAhead(r, 0)
+	}
+	if r.Size() != minReadAhead {
+		t.Fatalf("expected a quiet file to go back to %d bytes, got %d", minReadAhead, r.Size())
+	}
+	// the file carries on being read after EOF.
+	w.WriteString("four\n")
+	if line, err := r.ReadString('\n'); err != nil || line != "four\n" {
+		t.Fatalf("expected four, got %q, %v", line, err)
+	}
+}
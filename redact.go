@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// a redaction replaces whatever matches any of its patterns, in the text and
+// every field of an event, before the event is shipped.  If a pattern has a
+// group, only what the first group matches is replaced, so that e.g.
+// "token=(\\S+)" keeps the "token=" and redacts the value.
+//
+// By default each character of a match is replaced by the mask, "*" unless
+// set, keeping the length of the text.  With hash set, a match is instead
+// replaced by the pattern's name and the start of an HMAC-SHA256 of it, keyed
+// with hash_key, e.g. [card:5d41402abc4b2a76], so that the same value can
+// still be matched up across events without being revealed.  The key stops
+// short values, like card numbers, from being found by hashing every
+// possible value.
+//
+// Patterns are applied to each event separately, one after the other in order
+// of name, so a secret split across two events, e.g. by max_event_bytes,
+// isn't caught.  Events joined from several lines are redacted as a whole.
+type redaction struct {
+	Patterns map[string]string `json:"patterns"`
+	Mask     string            `json:"mask"`
+	Hash     bool              `json:"hash"`
+	HashKey  string            `json:"hash_key"`
+
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+func (r *redaction) UnmarshalJSON(b []byte) error {
+	type plain redaction
+	*r = redaction{}
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return fmt.Errorf("cannot unmarshal redact: %v", err)
+	}
+	if len(r.Patterns) == 0 {
+		return fmt.Errorf("illegal redact %s: no patterns", b)
+	}
+	if r.Hash && r.HashKey == "" {
+		return fmt.Errorf("illegal redact %s: hash needs a hash_key", b)
+	}
+	if r.Mask == "" {
+		r.Mask = "*"
+	}
+	for name := range r.Patterns {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+	for _, name := range r.names {
+		re, err := regexp.Compile(r.Patterns[name])
+		if err != nil {
+			return fmt.Errorf("illegal redact pattern %s: %v", name, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return nil
+}
+
+func (r *redaction) redact(s string) string {
+	for i, re := range r.patterns {
+		matches := re.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+		var out bytes.Buffer
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) > 2 {
+				start, end = m[2], m[3]
+				if start < 0 {
+					// the group didn't take part in the match.
+					continue
+				}
+			}
+			out.WriteString(s[last:start])
+			out.WriteString(r.replacement(r.names[i], s[start:end]))
+			last = end
+		}
+		out.WriteString(s[last:])
+		s = out.String()
+	}
+	return s
+}
+
+func (r *redaction) replacement(name, secret string) string {
+	if !r.Hash {
+		return strings.Repeat(r.Mask, utf8.RuneCountInString(secret))
+	}
+	mac := hmac.New(sha256.New, []byte(r.HashKey))
+	mac.Write([]byte(secret))
+	return "[" + name + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+}
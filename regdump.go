@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// registryDump prints the progress file at path as a table, for working out
+// where lumberjack thinks it's got to in each file without reading the JSON
+// by hand.  It's run with "lumberjack registry dump", and doesn't start any
+// harvesters.
+func registryDump(path string) {
+	var p progress
+	if err := p.load(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	writeRegistryTable(os.Stdout, p)
+	os.Exit(0)
+}
+
+// writes a row for each file in p, sorted by source, with the file's current
+// size and how far behind the recorded offset is, if the file is still there.
+// A file that's since been replaced by another at the same path is marked as
+// such, as its size says nothing about the recorded one.
+func writeRegistryTable(w io.Writer, p progress) {
+	sources := make([]string, 0, len(p))
+	for source := range p {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tOFFSET\tINODE\tDEVICE\tSIZE\tLAG")
+	for _, source := range sources {
+		state := p[source]
+		size, lag := "-", "gone"
+		if info, err := statFile(source); err == nil {
+			if is_file_same(source, info, state) {
+				size = strconv.FormatInt(info.Size(), 10)
+				lag = strconv.FormatInt(info.Size()-state.Offset, 10)
+			} else {
+				lag = "replaced"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\n",
+			source, state.Offset, state.Inode, state.Device, size, lag)
+	}
+	tw.Flush()
+}
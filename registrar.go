@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"time"
 )
 
 type progress map[string]*FileState
@@ -25,7 +27,28 @@ func (p *progress) load(path string) error {
 
 // records positions of files read
 func Registrar(input chan eventPage) {
-	for page := range input {
+	var clean <-chan time.Time
+	removed := make(map[string]time.Time)
+	if options.CleanRemoved {
+		clean = time.Tick(time.Minute)
+	}
+
+	for {
+		var page eventPage
+		select {
+		case page = <-input:
+		case <-clean:
+			if err := cleanRemoved(options.HistoryPath, removed, options.CleanRemovedGrace); err != nil {
+				log.Printf("unable to clean removed files from history: %v", err)
+			}
+			continue
+		case state := <-completions:
+			p := progress{state.Source: state}
+			if err := p.writeFile(options.HistoryPath); err != nil {
+				log.Printf("unable to write history to file: %s", err.Error())
+			}
+			continue
+		}
 		if page.empty() {
 			continue
 		}
@@ -34,8 +57,104 @@ func Registrar(input chan eventPage) {
 
 		log.Printf("registrar received %d events. %s", len(page), page.countString())
 
-		if err := p.writeFile(options.HistoryPath); err != nil {
-			log.Printf("unable to write history to file: %s", err.Error())
+		if useXattrs() {
+			p = p.writeXattrs()
+		}
+		if len(p) > 0 {
+			if err := p.writeFile(options.HistoryPath); err != nil {
+				log.Printf("unable to write history to file: %s", err.Error())
+			}
+		}
+		limitAcked(len(page))
+		inflightDone(page)
+	}
+}
+
+// drops the entries in the progress file for files that no longer exist, or
+// that have been replaced by a different file, and that aren't being
+// harvested.  Files can briefly go missing while they're being rotated, so an
+// entry is only dropped once its file has been gone for the grace period.
+// removed holds when each file was first seen to be gone.
+func cleanRemoved(path string, removed map[string]time.Time, grace time.Duration) error {
+	var p progress
+	if err := p.load(path); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	gone := make(map[string]bool)
+	for source, state := range p {
+		info, err := statFile(source)
+		if (err == nil && is_file_same(source, info, state)) || registry.byPath(source) != nil {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		if _, ok := removed[source]; !ok {
+			removed[source] = now
+		}
+		gone[source] = true
+	}
+
+	pruned := 0
+	for source, since := range removed {
+		if !gone[source] {
+			// it came back.
+			delete(removed, source)
+			continue
 		}
+		if now.Sub(since) >= grace {
+			log.Printf("removing %s from history: gone since %v", source, since)
+			delete(p, source)
+			delete(removed, source)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return nil
+	}
+	return p.save(path)
+}
+
+// keeps the progress file to at most max entries, on hosts where so many
+// files come and go that it would otherwise grow without bound.  Entries for
+// the files that were modified longest ago, or that no longer exist, are
+// dropped first.  Files that are being harvested are never dropped.  Returns
+// the number of entries dropped.
+func (p progress) evict(max int) int {
+	if max <= 0 || len(p) <= max {
+		return 0
+	}
+	type candidate struct {
+		source   string
+		modified time.Time // zero if the file is gone
+	}
+	var candidates []candidate
+	for source := range p {
+		if registry != nil && registry.byPath(source) != nil {
+			continue
+		}
+		var modified time.Time
+		if info, err := statFile(source); err == nil {
+			modified = info.ModTime()
+		}
+		candidates = append(candidates, candidate{source, modified})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].modified.Before(candidates[j].modified)
+	})
+
+	evicted := 0
+	for _, c := range candidates {
+		if len(p) <= max {
+			break
+		}
+		delete(p, c.source)
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("WARNING progress file has more than %d entries, dropped %d least recently modified", max, evicted)
 	}
+	return evicted
 }
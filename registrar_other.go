@@ -26,6 +26,21 @@ func loadRegistry(fname string) (map[string]*FileState, error) {
 }
 
 func (p *progress) writeFile(path string) error {
+	var existing progress
+	if err := existing.load(path); err != nil {
+		log.Printf("failed to read existing state at path %s: %s", path, err.Error())
+		existing = make(progress, 8)
+	}
+
+	for name, fs := range *p {
+		existing[name] = fs
+	}
+	existing.evict(options.MaxProgressEntries)
+	return existing.save(path)
+}
+
+// replaces the progress file at path with p.
+func (p *progress) save(path string) error {
 	f, err := ioutil.TempFile(options.TempDir, "lumberjack")
 	if err != nil {
 		return fmt.Errorf("failed to create temp file for writing: %s\n", err)
@@ -37,17 +52,7 @@ func (p *progress) writeFile(path string) error {
 		return fmt.Errorf("unable to stat temp file: %s", err.Error())
 	}
 
-	var existing progress
-	if err := existing.load(path); err != nil {
-		log.Printf("failed to read existing state at path %s: %s", path, err.Error())
-		existing = make(progress, 8)
-	}
-
-	for name, fs := range *p {
-		existing[name] = fs
-	}
-
-	if err := json.NewEncoder(f).Encode(existing); err != nil {
+	if err := json.NewEncoder(f).Encode(p); err != nil {
 		return fmt.Errorf("failed to write log state to file: %v", err)
 	}
 	if err := os.Rename(filepath.Join(options.TempDir, fi.Name()), path); err != nil {
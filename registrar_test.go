@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := make(progress)
+	for i, name := range []string{"old", "new", "newest"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(path, modified, modified)
+		p[path] = &FileState{Source: path}
+	}
+	gone := filepath.Join(dir, "gone")
+	p[gone] = &FileState{Source: gone}
+
+	if n := p.evict(2); n != 2 {
+		t.Fatalf("expected 2 entries evicted, got %d", n)
+	}
+	for _, name := range []string{"new", "newest"} {
+		if _, ok := p[filepath.Join(dir, name)]; !ok {
+			t.Fatalf("expected %s to be kept, got %v", name, p)
+		}
+	}
+}
+
+// a file whose inode is reused by a file with different contents mustn't be
+// resumed part way through.
+func TestProgressChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("first line\nsecond line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino, dev := file_ids(info)
+	p := progress{path: &FileState{Source: path, Offset: 23, Inode: ino, Device: dev}}
+	p.addChecksums(16)
+	state := p[path]
+	if state.Window != 16 || state.Checksum == "" {
+		t.Fatalf("expected a checksum of 16 bytes, got %+v", state)
+	}
+	if !state.contentMatches(path) {
+		t.Fatal("expected the unchanged file to match")
+	}
+
+	// rewritten in place, so the inode is the same.
+	if err := ioutil.WriteFile(path, []byte("other text, same length!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state.contentMatches(path) {
+		t.Fatal("expected the rewritten file not to match")
+	}
+}
+
+// a file's checksum isn't worked out again until its offset has moved a
+// whole window past where it was taken.
+func TestProgressChecksumsReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("first line\nsecond line\nthird line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino, dev := file_ids(info)
+	state := func(offset int64) *FileState {
+		p := progress{path: &FileState{Source: path, Offset: offset, Inode: ino, Device: dev}}
+		p.addChecksums(16)
+		return p[path]
+	}
+	first := state(11)
+	if !first.contentMatches(path) {
+		t.Fatal("expected the unchanged file to match")
+	}
+
+	// moved away, so it can't be read: the checksum taken at 11 is recorded
+	// again without reading it.
+	moved := filepath.Join(dir, "app.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	second := state(23)
+	if second.Checksum != first.Checksum || second.Checked != 11 {
+		t.Fatalf("expected the checksum taken at 11 to be reused, got %+v", second)
+	}
+	if !second.contentMatches(moved) {
+		t.Fatal("expected the reused checksum to match the file")
+	}
+	if third := state(34); third.Checksum != "" {
+		t.Fatalf("expected the checksum to be worked out again a window on, got %+v", third)
+	}
+}
+
+func TestWriteRegistryTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ino, dev := file_ids(info)
+	p := progress{path: &FileState{Source: path, Offset: 4, Inode: ino, Device: dev}}
+	gone := filepath.Join(dir, "gone.log")
+	p[gone] = &FileState{Source: gone, Offset: 7}
+
+	var buf bytes.Buffer
+	writeRegistryTable(&buf, p)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %q", buf.String())
+	}
+	if f := strings.Fields(lines[1]); f[0] != path || f[1] != "4" || f[4] != "10" || f[5] != "6" {
+		t.Errorf("unexpected row for a file still there: %q", lines[1])
+	}
+	if f := strings.Fields(lines[2]); f[0] != gone || f[1] != "7" || f[4] != "-" || f[5] != "gone" {
+		t.Errorf("unexpected row for a file that's gone: %q", lines[2])
+	}
+}
@@ -8,6 +8,21 @@ import (
 )
 
 func (p *progress) writeFile(path string) error {
+	var existing progress
+	if err := existing.load(path); err != nil {
+		log.Printf("failed to read existing state at path %s: %s", path, err.Error())
+		existing = make(progress, 8)
+	}
+
+	for name, fs := range *p {
+		existing[name] = fs
+	}
+	existing.evict(options.MaxProgressEntries)
+	return existing.save(path)
+}
+
+// replaces the progress file at path with p.
+func (p *progress) save(path string) error {
 	tmp := path + ".new"
 	file, err := os.Create(tmp)
 	if err != nil {
@@ -15,10 +30,10 @@ func (p *progress) writeFile(path string) error {
 	}
 
 	encoder := json.NewEncoder(file)
-	encoder.Encode(state)
+	encoder.Encode(p)
 	file.Close()
 
 	old := path + ".old"
 	os.Rename(path, old)
-	os.Rename(tmp, path)
+	return os.Rename(tmp, path)
 }
@@ -6,8 +6,10 @@ import (
 	"expvar"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 type fileId string
@@ -17,8 +19,15 @@ type hregistry struct {
 	RunningIds   map[fileId]*Harvester `json:"by_id"`
 	RunningPaths map[string]*Harvester `json:"by_path"`
 	paths        map[string]bool
+
+	// where harvesters that timed out stopped, by file.
+	closed map[fileId]int64
 }
 
+// bytes that files had grown by when they were reopened, having been closed
+// after going quiet.  See grown.
+var reopenBytesStat = expvar.NewInt("reopen_bytes")
+
 func newRegistry(conf *Config) *hregistry {
 	r := &hregistry{
 		RunningIds:   make(map[fileId]*Harvester, len(conf.Files)),
@@ -34,7 +43,7 @@ func newRegistry(conf *Config) *hregistry {
 	return r
 }
 
-func (r hregistry) String() string {
+func (r *hregistry) String() string {
 	r.RLock()
 	defer r.RUnlock()
 
@@ -50,7 +59,7 @@ func (r hregistry) String() string {
 	return buf.String()
 }
 
-func (r hregistry) register(v *Harvester) error {
+func (r *hregistry) register(v *Harvester) error {
 	r.Lock()
 	defer r.Unlock()
 
@@ -63,18 +72,27 @@ func (r hregistry) register(v *Harvester) error {
 		return fmt.Errorf("file is already being harvested: %v", v)
 	}
 
-	if _, ok := r.RunningPaths[v.Path]; ok {
-		return fmt.Errorf("path is already being harvested: %v", v)
-
+	if prev, ok := r.RunningPaths[v.Path]; ok {
+		// the file prev is reading was at this path, but if v's file is
+		// there now, prev's has been rotated away, and v takes the path
+		// over.  The rename may not have been noticed yet, or at all when
+		// polling.
+		info, err := os.Stat(v.Path)
+		if err != nil || v.fi == nil || !is_fileinfo_same(v.fi, info) {
+			return fmt.Errorf("path is already being harvested: %v", v)
+		}
+		log.Printf("%s has been replaced, marking its old file as rotated", v.Path)
+		prev.moved = true
 	}
 	r.RunningIds[id] = v
 	r.RunningPaths[v.Path] = v
+	v.done = make(chan struct{})
 
 	log.Printf("registrary registered: %v", v)
 	return nil
 }
 
-func (r hregistry) unregister(v *Harvester) error {
+func (r *hregistry) unregister(v *Harvester) error {
 	r.Lock()
 	defer r.Unlock()
 
@@ -87,25 +105,32 @@ func (r hregistry) unregister(v *Harvester) error {
 		return fmt.Errorf("unable to unregister harvester: id %s wasn't registered", id)
 	}
 
-	if _, ok := r.RunningPaths[v.Path]; !ok {
-		return fmt.Errorf("unable to unregister harvester: path %s wasn't registered", v.Path)
-	}
 	delete(r.RunningIds, id)
-	delete(r.RunningPaths, v.Path)
+	if v.timedOut && !v.moved {
+		if r.closed == nil {
+			r.closed = make(map[fileId]int64)
+		}
+		r.closed[id] = v.handoff
+	}
+	if r.RunningPaths[v.Path] == v {
+		// otherwise the path has been taken over by another file.
+		delete(r.RunningPaths, v.Path)
+	}
+	close(v.done)
 
 	log.Printf("registrar unregistered: %v", v)
 	return nil
 }
 
-func (r hregistry) byPath(path string) *Harvester {
+func (r *hregistry) byPath(path string) *Harvester {
 	r.RLock()
 	defer r.RUnlock()
 
 	return r.RunningPaths[path]
 }
 
-func (r hregistry) byPathStat(path string) *Harvester {
-	fi, err := os.Stat(path)
+func (r *hregistry) byPathStat(path string) *Harvester {
+	fi, err := statFile(path)
 	if err != nil {
 		log.Printf("registry can't stat file: %v", err)
 		return nil
@@ -113,18 +138,41 @@ func (r hregistry) byPathStat(path string) *Harvester {
 	return r.byId(filestring(fi))
 }
 
-func (r hregistry) byId(id fileId) *Harvester {
+func (r *hregistry) byId(id fileId) *Harvester {
 	r.RLock()
 	defer r.RUnlock()
 
 	return r.RunningIds[id]
 }
 
-func (r hregistry) rename(prev, curr string) {
+// if the file info is for a file whose harvester timed out, and which has
+// grown since, returns the offset its harvester stopped at, which a new
+// harvester should carry on from.  The file is then no longer counted as
+// closed.
+func (r *hregistry) grown(info os.FileInfo) (int64, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	id := filestring(info)
+	offset, ok := r.closed[id]
+	if !ok || info.Size() <= offset {
+		return 0, false
+	}
+	delete(r.closed, id)
+	reopenBytesStat.Add(info.Size() - offset)
+	return offset, true
+}
+
+func (r *hregistry) rename(prev, curr string) {
 	r.Lock()
 	defer r.Unlock()
 
 	h, ok := r.RunningPaths[prev]
+	if info, err := statFile(curr); err == nil {
+		// prev's path may have been taken over already by the file that
+		// replaced it.
+		h, ok = r.RunningIds[filestring(info)]
+	}
 	if !ok {
 		// log.Printf("registry didn't have a record for any harvester at %s", prev)
 		return
@@ -139,5 +187,59 @@ func (r hregistry) rename(prev, curr string) {
 	h.Path = curr
 	h.moved = true
 	r.RunningPaths[curr] = h
-	delete(r.RunningPaths, prev)
+	if r.RunningPaths[prev] == h {
+		delete(r.RunningPaths, prev)
+	}
+}
+
+// the state of the harvesting of a single file, for operational tooling.
+type harvestState struct {
+	Path      string    `json:"path"`
+	Harvested bool      `json:"harvested"`           // false if no harvester has the path open
+	Offset    int64     `json:"offset"`              // position of the harvester's file handle
+	LastRead  time.Time `json:"last_read,omitempty"` // when a line was last read
+	Moved     bool      `json:"moved"`               // whether the file has been rotated away
+}
+
+// looks up the harvester currently reading the file at path.
+func (r *hregistry) state(path string) (harvestState, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	s := harvestState{Path: path}
+	h, ok := r.RunningPaths[path]
+	if !ok {
+		return s, nil
+	}
+	offset, err := h.fileOffset()
+	if err != nil {
+		return s, fmt.Errorf("unable to get offset of %s: %v", path, err)
+	}
+	s.Harvested = true
+	s.Offset = offset
+	s.LastRead = h.lastRead
+	s.Moved = h.moved
+	return s, nil
+}
+
+// serves the harvest state of the file given by the path query parameter,
+// e.g. /harvester?path=/var/log/app.log, on the -http port.
+func registerHarvesterHandler() {
+	http.HandleFunc("/harvester", func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		s, err := registry.state(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !s.Harvested {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(s)
+	})
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRegistryState(t *testing.T) {
+	h := fileHarvester(t, "one\ntwo\n", make(chan *FileEvent))
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+
+	if s, err := registry.state(h.Path); err != nil || s.Harvested {
+		t.Fatalf("expected %s not to be harvested, got %+v, %v", h.Path, s, err)
+	}
+
+	if err := registry.register(h); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.unregister(h)
+	h.file.Seek(4, os.SEEK_SET)
+	s, err := registry.state(h.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Harvested || s.Offset != 4 || s.Moved {
+		t.Fatalf("unexpected state %+v", s)
+	}
+}
+
+// a file is replaced at its path before the rename is noticed.  The new
+// file's harvester takes the path over.
+func TestRegistryReplacedPath(t *testing.T) {
+	old := fileHarvester(t, "one\n", make(chan *FileEvent))
+	defer old.file.Close()
+	path := old.Path
+	if err := registry.register(old); err != nil {
+		t.Fatal(err)
+	}
+	defer registry.unregister(old)
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path + ".1")
+	h := fileHarvester(t, "two\n", make(chan *FileEvent))
+	defer h.file.Close()
+	if err := os.Rename(h.Path, path); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	h.Path = path
+
+	if err := registry.register(h); err != nil {
+		t.Fatalf("expected the new file to take the path over, got %v", err)
+	}
+	defer registry.unregister(h)
+	if !old.moved || registry.byPath(path) != h {
+		t.Fatalf("expected the old file to be marked rotated")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// a running harvester can be moved to another position in its file with the
+// reset command on the command port, e.g. to re-ship part of a file that
+// Logstash lost, or to skip a burst of junk.  The harvester does the move
+// itself, between lines: it sends on any joined event it's holding, waits for
+// everything it has sent to be acknowledged, and only then seeks, so that no
+// acknowledgement of an event from before the reset can record a position
+// from before it.  The new position is recorded once events read from it are
+// acknowledged.  If that takes longer than resetWait, the reset is given up
+// on, and the harvester carries on from where it was.
+var resetCmd = cmd{
+	name: "reset",
+	run: func(args []string, w io.Writer) {
+		if len(args) == 0 || len(args) > 2 {
+			fmt.Fprintln(w, "usage: reset [filename] [offset]")
+			return
+		}
+		var offset int64
+		if len(args) == 2 {
+			var err error
+			offset, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil || offset < 0 {
+				fmt.Fprintf(w, "invalid offset: %s\n", args[1])
+				return
+			}
+		}
+		h := registry.byPath(args[0])
+		if h == nil {
+			fmt.Fprintf(w, "no harvester is running for %s; use replay to read it from an offset\n", args[0])
+			return
+		}
+		if h.gzip {
+			fmt.Fprintf(w, "can't reset %s: it's gzip compressed\n", args[0])
+			return
+		}
+		if info, err := os.Stat(args[0]); err == nil && offset > info.Size() {
+			fmt.Fprintf(w, "offset %d is past the end of %s, at %d\n", offset, args[0], info.Size())
+			return
+		}
+		h.requestSeek(offset)
+		fmt.Fprintln(w, "ok")
+	},
+}
+
+func init() {
+	registerCmd(resetCmd)
+}
+
+// how long a reset waits for acknowledgements.  See resetCmd.
+var resetWait = time.Minute
+
+// asks the harvester to move to offset, replacing any earlier request it
+// hasn't got round to yet.
+func (h *Harvester) requestSeek(offset int64) {
+	for {
+		select {
+		case h.seekTo <- offset:
+			return
+		default:
+		}
+		select {
+		case <-h.seekTo:
+		default:
+		}
+	}
+}
+
+// moves the harvester to offset, reading with r from there on.  See resetCmd.
+func (h *Harvester) seek(offset int64, r *bufio.Reader) error {
+	h.flush()
+	id, err := h.fileId()
+	if err != nil {
+		return err
+	}
+	if acks.unacknowledged(id) {
+		log.Printf("harvester for %s waiting for acknowledgements before resetting to %d", h.Path, offset)
+		if err := h.waitAcknowledged(id, resetWait); err != nil {
+			return err
+		}
+	}
+	if _, err := h.file.Seek(offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	acks.reset(id, offset)
+	r.Reset(h.fileReader())
+	h.lastLine = nil
+	h.danglingCR = false
+	h.skip = 0
+	if offset == 0 {
+		h.skip = h.conf.SkipLines
+	}
+	h.reopened = true
+	log.Printf("harvester for %s reset to offset %d", h.Path, offset)
+	return nil
+}
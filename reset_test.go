@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a running harvester that's reset goes back and reads again from the
+// offset given, once what it has already sent has been acknowledged.
+func TestResetCmd(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntwo\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	done := startReadlines(h, time.Minute)
+	var page eventPage
+	for _, expected := range []string{"one", "two"} {
+		e := <-out
+		if e.Text != expected {
+			t.Fatalf("expected %q, got %q", expected, e.Text)
+		}
+		page = append(page, e)
+	}
+
+	var w bytes.Buffer
+	resetCmd.run([]string{h.Path, "4"}, &w)
+	if w.String() != "ok\n" {
+		t.Fatalf("unexpected reply %q", w.String())
+	}
+	select {
+	case e := <-out:
+		t.Fatalf("reset before earlier events were acknowledged, read %q", e.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+	acks.acknowledge(page)
+	select {
+	case e := <-out:
+		if e.Text != "two" || e.Offset != 4 {
+			t.Fatalf("expected two at 4, got %q at %d", e.Text, e.Offset)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the harvester to reset")
+	}
+
+	w.Reset()
+	resetCmd.run([]string{h.Path, "100"}, &w)
+	if !strings.Contains(w.String(), "past the end") {
+		t.Fatalf("expected an offset past the end to be refused, got %q", w.String())
+	}
+
+	atomic.StoreInt32(&h.inactive, 1)
+	<-done
+	w.Reset()
+	resetCmd.run([]string{h.Path}, &w)
+	if !strings.Contains(w.String(), "no harvester") {
+		t.Fatalf("expected a path not being harvested to be refused, got %q", w.String())
+	}
+}
+
+// a reset that's still waiting for acknowledgements after resetWait is given
+// up on, and the harvester carries on from where it was.
+func TestResetWaitTimeout(t *testing.T) {
+	defer func(d time.Duration) { eofPoll = d }(eofPoll)
+	eofPoll = 10 * time.Millisecond
+	defer func(d time.Duration) { resetWait = d }(resetWait)
+	resetWait = 50 * time.Millisecond
+
+	out := make(chan *FileEvent, 16)
+	h := fileHarvester(t, "one\ntwo\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+	defer endHarvesters()
+	startReadlines(h, time.Minute)
+	for _, expected := range []string{"one", "two"} {
+		if e := <-out; e.Text != expected {
+			t.Fatalf("expected %q, got %q", expected, e.Text)
+		}
+	}
+
+	var w bytes.Buffer
+	resetCmd.run([]string{h.Path, "0"}, &w)
+	time.Sleep(2 * resetWait)
+	f, err := os.OpenFile(h.Path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("three\n")
+	f.Close()
+	select {
+	case e := <-out:
+		if e.Text != "three" || e.Offset != 8 {
+			t.Fatalf("expected three at 8, got %q at %d", e.Text, e.Offset)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("harvester stopped after giving up on the reset")
+	}
+}
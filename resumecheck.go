@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// checksums the window bytes of f before offset, or as many as there are, so
+// that a file can be recognised on resuming by its contents as well as its
+// inode, which may have been reused by a different file.
+func windowChecksum(f io.ReaderAt, offset int64, window int) (int, string, error) {
+	if int64(window) > offset {
+		window = int(offset)
+	}
+	buf := make([]byte, window)
+	if _, err := f.ReadAt(buf, offset-int64(window)); err != nil {
+		return 0, "", err
+	}
+	return window, fmt.Sprintf("%08x", crc32.ChecksumIEEE(buf)), nil
+}
+
+// the checksum last recorded for each path.  See addChecksums.
+var windowSums = struct {
+	sync.Mutex
+	m map[string]FileState
+}{m: make(map[string]FileState)}
+
+// records, with -resume-window, the checksum of the bytes before each file's
+// offset.  Files that can't be read, or that have been replaced, are recorded
+// without one.
+//
+// Reading the file back for every page would cost a busy file a read per
+// page, so a file's last checksum is recorded again, with where it was taken,
+// until the offset has moved a whole window past it.  The bytes it covers
+// are still before the offset, so they're as good a check.
+func (p progress) addChecksums(window int) {
+	windowSums.Lock()
+	defer windowSums.Unlock()
+	for path, state := range p {
+		last, ok := windowSums.m[path]
+		sameFile := ok && last.Inode == state.Inode && last.Device == state.Device && last.Birth == state.Birth
+		if sameFile && last.Checked <= state.Offset && state.Offset-last.Checked < int64(window) {
+			state.Window, state.Checksum, state.Checked = last.Window, last.Checksum, last.Checked
+			continue
+		}
+		delete(windowSums.m, path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if info, err := statOpen(f); err == nil && is_file_same(path, info, state) {
+			if n, sum, err := windowChecksum(f, state.Offset, window); err == nil {
+				state.Window, state.Checksum, state.Checked = n, sum, state.Offset
+				windowSums.m[path] = *state
+			}
+		}
+		f.Close()
+	}
+}
+
+// whether the bytes before the recorded offset in the file at path are still
+// those that were there when the offset was recorded.  States without a
+// checksum always match.
+func (s *FileState) contentMatches(path string) bool {
+	if s.Checksum == "" {
+		return true
+	}
+	end := s.Offset
+	if s.Checked > 0 {
+		end = s.Checked
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, sum, err := windowChecksum(f, end, s.Window)
+	if err != nil {
+		log.Printf("unable to checksum %s before resuming: %v", path, err)
+		return false
+	}
+	return sum == s.Checksum
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"syscall"
+	"time"
+)
+
+// the longest a retryReader waits between attempts.
+const maxRetryBackoff = time.Second
+
+// how long a retryReader retries for, unless read_retry_ms says otherwise.
+const defaultReadRetry = time.Minute
+
+// transient reports whether err is a read error that's worth retrying, as
+// opposed to one that means the file can't be read any more.
+func transient(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// unavailable reports whether err means the filesystem a file is on has gone
+// away, as happens when a network mount drops.  The file may be readable
+// again once it's reopened.
+func unavailable(err error) bool {
+	return errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.ENXIO)
+}
+
+// retryReader retries reads that fail with a transient error, backing off
+// between attempts, so that an interrupted read on some filesystems doesn't
+// stop a harvester.  Any other error, or a transient one that's still
+// happening after limit, is returned.
+type retryReader struct {
+	r     io.Reader
+	name  string
+	limit time.Duration
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	backoff := 10 * time.Millisecond
+	var start time.Time
+	for {
+		n, err := rr.r.Read(p)
+		if err == nil || !transient(err) {
+			return n, err
+		}
+		if n > 0 {
+			// the error will come back on the next read if it's still there.
+			return n, nil
+		}
+		if start.IsZero() {
+			start = time.Now()
+		} else if time.Since(start) >= rr.limit {
+			return 0, fmt.Errorf("still failing after retrying for %v: %w", rr.limit, err)
+		}
+		log.Printf("transient error reading %s, retrying in %v: %v", rr.name, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// a log directory can describe its own logs with a sidecar file, named by
+// fields_from_sidecar, holding a flat JSON object of fields for every event
+// read from files next to it.  Each sidecar is read once, and then again only
+// when its size or modification time changes, which is checked at most every
+// sidecarCheckInterval.
+var sidecarCheckInterval = 10 * time.Second
+
+var sidecars = struct {
+	sync.Mutex
+	files map[string]*sidecar
+}{files: make(map[string]*sidecar)}
+
+type sidecar struct {
+	checked time.Time // when the file was last stat'd
+	modTime time.Time
+	size    int64
+	fields  map[string]string // nil if there's no sidecar
+}
+
+// returns the fields in the sidecar called name in the directory of the log
+// file at path.  The map returned mustn't be modified.
+func sidecarFields(path, name string, now time.Time) map[string]string {
+	file := filepath.Join(filepath.Dir(path), name)
+
+	sidecars.Lock()
+	defer sidecars.Unlock()
+	s, ok := sidecars.files[file]
+	if !ok {
+		s = &sidecar{}
+		sidecars.files[file] = s
+	} else if now.Sub(s.checked) < sidecarCheckInterval {
+		return s.fields
+	}
+	s.checked = now
+
+	info, err := os.Stat(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR unable to stat sidecar %s: %v", file, err)
+		} else if s.fields != nil {
+			log.Printf("sidecar %s removed", file)
+			*s = sidecar{checked: now}
+		}
+		return s.fields
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.fields
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	fields, err := loadFieldsFile(file)
+	if err != nil {
+		// keep the fields we had until it's fixed.
+		log.Printf("ERROR %v", err)
+		return s.fields
+	}
+	s.fields = fields
+	log.Printf("loaded %d fields from sidecar %s", len(fields), file)
+	return s.fields
+}
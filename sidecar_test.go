@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSidecarFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	meta := filepath.Join(dir, "meta.json")
+	if err := ioutil.WriteFile(meta, []byte(`{"service": "billing", "version": "1.2"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan *FileEvent, 1)
+	h := newHarvester(filepath.Join(dir, "app.log"), &FileConfig{
+		FieldsFromSidecar: "meta.json",
+		Fields:            map[string]string{"service": "payments"},
+	}, out)
+	e := h.event("line\n", 0, nil)
+	if e.Fields["version"] != "1.2" || e.Fields["service"] != "payments" {
+		t.Fatalf("expected the sidecar's version and the config's service, got %v", e.Fields)
+	}
+
+	// a change isn't noticed until the sidecar is next checked.
+	now := time.Now()
+	if err := ioutil.WriteFile(meta, []byte(`{"version": "1.3"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(time.Minute)
+	if err := os.Chtimes(meta, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fields := sidecarFields(h.Path, "meta.json", now); fields["version"] != "1.2" {
+		t.Fatalf("expected the cached fields, got %v", fields)
+	}
+	if fields := sidecarFields(h.Path, "meta.json", later); fields["version"] != "1.3" {
+		t.Fatalf("expected the sidecar to be reloaded, got %v", fields)
+	}
+
+	os.Remove(meta)
+	if fields := sidecarFields(h.Path, "meta.json", later.Add(time.Minute)); fields != nil {
+		t.Fatalf("expected no fields once the sidecar is removed, got %v", fields)
+	}
+}
@@ -0,0 +1,19 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// registers c to receive the signal that toggles pausing.
+func notifyPause(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+// registers c to receive the signal that flushes the spools.
+func notifyFlush(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+func notifyPause(c chan os.Signal) {
+	log.Printf("Pausing with a signal not supported on this platform\n")
+}
+
+func notifyFlush(c chan os.Signal) {
+	log.Printf("Flushing with a signal not supported on this platform\n")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// listens on the prospector's unix socket, and harvests each connection to
+// it as a stream of lines, until the connection is closed.  Events from a
+// socket have the socket's path as their source, and their positions aren't
+// recorded: anything sent over a connection that's dropped before its events
+// are shipped is lost.
+func harvestUnixSocket(conf *FileConfig, out chan *FileEvent) error {
+	path := conf.UnixSocket
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// left over from a previous run.
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", path, err)
+	}
+	onShutdown(func() { l.Close() })
+	log.Printf("listening for lines on unix socket %s", path)
+
+	stopped := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					log.Printf("unable to accept connection on %s, retrying: %v", path, err)
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				stopped <- fmt.Errorf("unable to accept connection on %s: %v", path, err)
+				return
+			}
+			go harvestConn(path, conn, conf, out)
+		}
+	}()
+
+	return listenerAlive("unix:"+path, stopped)
+}
+
+// reports a listening prospector as healthy for as long as it's listening:
+// until an error comes in on stopped, which is returned.
+func listenerAlive(name string, stopped <-chan error) error {
+	for {
+		healthScanned(name)
+		select {
+		case err := <-stopped:
+			return err
+		case <-time.After(prospectInterval):
+		}
+	}
+}
+
+func harvestConn(path string, conn net.Conn, conf *FileConfig, out chan *FileEvent) {
+	defer conn.Close()
+	log.Printf("harvesting connection to %s", path)
+	h := newReaderHarvester(path, conn, conf, out)
+	h.readlines(0)
+	log.Printf("connection to %s closed", path)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHarvestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lines.sock")
+
+	out := make(chan *FileEvent, 16)
+	conf := &FileConfig{UnixSocket: path, Fields: map[string]string{"app": "web"}}
+	go harvestUnixSocket(conf, out)
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("one\ntwo"))
+	conn.Close()
+
+	for _, expected := range []string{"one", "two"} {
+		select {
+		case e := <-out:
+			if e.Text != expected || e.Source != path || e.Fields["app"] != "web" {
+				t.Fatalf("expected %q from %s, got %q from %s with %v", expected, path, e.Text, e.Source, e.Fields)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
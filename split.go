@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"unicode/utf8"
+)
+
+// splits an event whose text is longer than max bytes into several events,
+// so that a huge line doesn't get a whole batch rejected downstream.  Each
+// part gets split_id, split_index and split_total fields, so that the parts
+// can be put back together.  The parts all share the original's offset; only
+// the last one carries the original's length, so the file's position only
+// moves past the line once every part has been acknowledged.
+func splitEvent(e *FileEvent, max int) []*FileEvent {
+	if max <= 0 || len(e.Text) <= max {
+		return []*FileEvent{e}
+	}
+
+	var texts []string
+	for text := e.Text; text != ""; {
+		n := max
+		if n >= len(text) {
+			n = len(text)
+		} else {
+			// don't cut a character in half.
+			for n > 0 && !utf8.RuneStart(text[n]) {
+				n--
+			}
+			if n == 0 {
+				n = max
+			}
+		}
+		texts = append(texts, text[:n])
+		text = text[n:]
+	}
+
+	id := splitId()
+	total := strconv.Itoa(len(texts))
+	parts := make([]*FileEvent, len(texts))
+	for i, text := range texts {
+		part := *e
+		part.Text = text
+		part.length = 0
+		part.Fields = make(map[string]string, len(e.Fields)+3)
+		for k, v := range e.Fields {
+			part.Fields[k] = v
+		}
+		part.Fields["split_id"] = id
+		part.Fields["split_index"] = strconv.Itoa(i)
+		part.Fields["split_total"] = total
+		parts[i] = &part
+	}
+	parts[len(parts)-1].length = e.length
+	return parts
+}
+
+func splitId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -4,11 +4,15 @@ import (
 	"time"
 )
 
-// buffers events until ready to flush to the publisher
+// buffers events until ready to flush to the publisher.  Events come one at
+// a time on input, or in batches, from harvesters with emit_batch_size set.
 func Spool(input chan *FileEvent,
+	batches chan []*FileEvent,
 	output chan eventPage,
 	max_size uint64,
-	idle_timeout time.Duration) {
+	idle_timeout time.Duration,
+	order string,
+	max_bytes int) {
 	// heartbeat periodically. If the last flush was longer than
 	// 'idle_timeout' time ago, then we'll force a flush to prevent us from
 	// holding on to spooled events for too long.
@@ -22,25 +26,77 @@ func Spool(input chan *FileEvent,
 	// Current write position in the spool
 	var spool_i int = 0
 
+	// bytes the spooled events take up in data frames.  See frameSize.
+	var spool_bytes int = 0
+
 	next_flush_time := time.Now().Add(idle_timeout)
-	for {
-		select {
-		case event := <-input:
-			//append(spool, event)
-			spool[spool_i] = event
-			spool_i++
+	flush := flushRequested()
 
-			// Flush if full
-			if spool_i == cap(spool) {
-				//spoolcopy := make([]*FileEvent, max_size)
-				var spoolcopy []*FileEvent
-				//fmt.Println(spool[0])
-				spoolcopy = append(spoolcopy, spool[:]...)
+	// events taken from input or batches but not yet added to the spool.
+	// They, and what's in the spool, have been counted as sent, so if the
+	// spooler panics they're put back on input for it to pick up again once
+	// it's restarted, rather than never being acknowledged.
+	var unspooled []*FileEvent
+	defer func() {
+		if r := recover(); r != nil {
+			lost := append(append([]*FileEvent(nil), spool[:spool_i]...), unspooled...)
+			go func() {
+				for _, event := range lost {
+					input <- event
+				}
+			}()
+			panic(r)
+		}
+	}()
 
-				output <- spoolcopy
-				next_flush_time = time.Now().Add(idle_timeout)
+	// adds an event to the spool, sending what's spooled if that fills it.
+	add := func(event *FileEvent) {
+		// send what we have first if this event would take the page past
+		// max_bytes.  An event bigger than that on its own still goes,
+		// alone.
+		var size int
+		if max_bytes > 0 {
+			size = event.frameSize()
+		}
+		if max_bytes > 0 && spool_i > 0 && spool_bytes+size > max_bytes {
+			var spoolcopy []*FileEvent
+			spoolcopy = append(spoolcopy, spool[0:spool_i]...)
+			orderPage(spoolcopy, order)
+			output <- spoolcopy
+			next_flush_time = time.Now().Add(idle_timeout)
+			spool_i, spool_bytes = 0, 0
+		}
+
+		//append(spool, event)
+		spool[spool_i] = event
+		spool_i++
+		unspooled = unspooled[1:]
+		spool_bytes += size
+
+		// Flush if full
+		if spool_i == cap(spool) {
+			//spoolcopy := make([]*FileEvent, max_size)
+			var spoolcopy []*FileEvent
+			//fmt.Println(spool[0])
+			spoolcopy = append(spoolcopy, spool[:]...)
+			orderPage(spoolcopy, order)
 
-				spool_i = 0
+			output <- spoolcopy
+			next_flush_time = time.Now().Add(idle_timeout)
+
+			spool_i, spool_bytes = 0, 0
+		}
+	}
+
+	for {
+		select {
+		case event := <-input:
+			unspooled = []*FileEvent{event}
+			add(event)
+		case batch := <-batches:
+			unspooled = batch
+			for _, event := range batch {
+				add(event)
 			}
 		case <-ticker.C:
 			//fmt.Println("tick")
@@ -53,12 +109,23 @@ func Spool(input chan *FileEvent,
 				if spool_i > 0 {
 					var spoolcopy []*FileEvent
 					spoolcopy = append(spoolcopy, spool[0:spool_i]...)
+					orderPage(spoolcopy, order)
 					output <- spoolcopy
 					next_flush_time = now.Add(idle_timeout)
-					spool_i = 0
+					spool_i, spool_bytes = 0, 0
 				}
 			} /* if 'now' is after 'next_flush_time' */
 			/* case ... */
+		case <-flush:
+			flush = flushRequested()
+			if spool_i > 0 {
+				var spoolcopy []*FileEvent
+				spoolcopy = append(spoolcopy, spool[0:spool_i]...)
+				orderPage(spoolcopy, order)
+				output <- spoolcopy
+				next_flush_time = time.Now().Add(idle_timeout)
+				spool_i, spool_bytes = 0, 0
+			}
 		} /* select */
 	} /* for */
 } /* spool */
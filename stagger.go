@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// staggerSpec spreads out the starting of harvesters, so that a host with
+// thousands of files to harvest doesn't open and start reading them all at
+// once.  Harvesters are started Batch at a time, Interval apart, give or take
+// a fifth of Interval so that several lumberjacks started together drift
+// apart.
+type staggerSpec struct {
+	Batch      int `json:"batch"`
+	IntervalMs int `json:"interval_ms"`
+}
+
+type launch struct {
+	h      *Harvester
+	offset int64
+	opt    int
+}
+
+// harvesters started by startHarvester that haven't returned yet.
+var harvesting sync.WaitGroup
+
+// harvesters waiting to be started.  Nil if harvesters aren't staggered.
+var launches *launchQueue
+
+type launchQueue struct {
+	sync.Mutex
+	cond    *sync.Cond
+	pending []launch
+}
+
+// starts h harvesting, now or when the stagger allows.
+func startHarvester(h *Harvester, offset int64, opt int) {
+	harvesting.Add(1)
+	if launches == nil {
+		launch{h, offset, opt}.start()
+		return
+	}
+	launches.Lock()
+	launches.pending = append(launches.pending, launch{h, offset, opt})
+	launches.Unlock()
+	launches.cond.Signal()
+}
+
+func (l launch) start() {
+	go func() {
+		defer harvesting.Done()
+		l.h.Harvest(l.offset, l.opt)
+	}()
+}
+
+// makes startHarvester queue harvesters, to be started according to spec.
+func staggerHarvesters(spec *staggerSpec) {
+	q := &launchQueue{}
+	q.cond = sync.NewCond(q)
+	launches = q
+	go q.run(spec)
+}
+
+func (q *launchQueue) run(spec *staggerSpec) {
+	batch := spec.Batch
+	if batch <= 0 {
+		batch = 1
+	}
+	interval := time.Duration(spec.IntervalMs) * time.Millisecond
+	for {
+		q.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		n := batch
+		if n > len(q.pending) {
+			n = len(q.pending)
+		}
+		next := q.pending[:n:n]
+		q.pending = q.pending[n:]
+		q.Unlock()
+
+		for _, l := range next {
+			l.start()
+		}
+		if interval > 0 {
+			jitter := time.Duration(rand.Int63n(int64(interval)/5+1)) - interval/10
+			time.Sleep(interval + jitter)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaggerHarvesters(t *testing.T) {
+	defer func() { launches = nil }()
+	defer endHarvesters()
+	staggerHarvesters(&staggerSpec{Batch: 2, IntervalMs: 200})
+
+	out := make(chan *FileEvent, 16)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		startHarvester(newReaderHarvester("test", strings.NewReader("line\n"), nil, out), 0, 0)
+	}
+	for i := 0; i < 2; i++ {
+		<-out
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("first batch took %v to start", elapsed)
+	}
+	<-out
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("third harvester started after only %v", elapsed)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// dropStale passes the events in page that were read longer than maxEventAge
+// ago straight to the registrar, as though they'd been sent, so their files'
+// positions move past them, and returns the rest to be sent.  A publisher that
+// has been unable to send for a long time would otherwise ship logs nobody
+// wants any more.
+func (p *Publisher) dropStale(page eventPage, registrar chan eventPage) eventPage {
+	if p.maxEventAge <= 0 {
+		return page
+	}
+	var fresh, stale eventPage
+	cutoff := time.Now().Add(-p.maxEventAge)
+	for _, e := range page {
+		if !e.readAt.IsZero() && e.readAt.Before(cutoff) {
+			stale = append(stale, e)
+		} else {
+			fresh = append(fresh, e)
+		}
+	}
+	if len(stale) == 0 {
+		return page
+	}
+	log.Printf("WARNING publisher %d dropping %d events read over %v ago", p.id, len(stale), p.maxEventAge)
+	p.stats.stale.Add(int64(len(stale)))
+	registrar <- stale
+	return fresh
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDropStale(t *testing.T) {
+	p := &Publisher{maxEventAge: time.Hour, stats: newPublisherStats("stale-test")}
+	old := &FileEvent{Text: "old", readAt: time.Now().Add(-2 * time.Hour)}
+	recent := &FileEvent{Text: "recent", readAt: time.Now()}
+	registrar := make(chan eventPage, 1)
+
+	fresh := p.dropStale(eventPage{old, recent}, registrar)
+	if len(fresh) != 1 || fresh[0] != recent {
+		t.Fatalf("expected just the recent event to be kept, got %v", fresh)
+	}
+	select {
+	case stale := <-registrar:
+		if len(stale) != 1 || stale[0] != old {
+			t.Fatalf("expected the old event to be passed to the registrar, got %v", stale)
+		}
+	default:
+		t.Fatal("expected the old event to be passed to the registrar")
+	}
+	if n := p.stats.stale.Value(); n != 1 {
+		t.Fatalf("expected 1 stale event counted, got %d", n)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"io/ioutil"
+	"runtime"
+)
+
+// stats exposed through expvar on the http port.
+var (
+	// bytes between each harvester's offset and the end of its file
+	readLagStat = expvar.NewMap("read_lag_bytes")
+
+	// files being backfilled: waiting for a slot, reading, and read to the end
+	backfillStat = expvar.NewMap("backfill")
+)
+
+// process resource usage, to correlate with the number of harvesters.
+type resourceStats struct {
+	Goroutines      int `json:"goroutines"`
+	OpenFiles       int `json:"open_files"` // -1 if unknown
+	ActiveHarvester int `json:"active_harvesters"`
+}
+
+func currentResourceStats() resourceStats {
+	s := resourceStats{
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  openFileCount(),
+	}
+	if registry != nil {
+		registry.RLock()
+		s.ActiveHarvester = len(registry.RunningIds)
+		registry.RUnlock()
+	}
+	return s
+}
+
+// counts this process's open file descriptors.  Only works where /proc is
+// available.
+func openFileCount() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+func init() {
+	expvar.Publish("resources", expvar.Func(func() interface{} {
+		return currentResourceStats()
+	}))
+}
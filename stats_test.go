@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestResourceStats(t *testing.T) {
+	out := make(chan *FileEvent, 1)
+	h := fileHarvester(t, "one\n", out)
+	defer os.Remove(h.Path)
+	defer h.file.Close()
+
+	before := currentResourceStats()
+	if before.Goroutines < 1 {
+		t.Fatalf("expected goroutines to be counted, got %d", before.Goroutines)
+	}
+	if err := registry.register(h); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(h.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := currentResourceStats()
+	f.Close()
+	registry.unregister(h)
+
+	if after.ActiveHarvester != before.ActiveHarvester+1 {
+		t.Fatalf("expected one more active harvester, got %d then %d", before.ActiveHarvester, after.ActiveHarvester)
+	}
+	if runtime.GOOS == "linux" && after.OpenFiles != before.OpenFiles+1 {
+		t.Fatalf("expected one more open file, got %d then %d", before.OpenFiles, after.OpenFiles)
+	}
+
+	var published resourceStats
+	if err := json.Unmarshal([]byte(expvar.Get("resources").String()), &published); err != nil {
+		t.Fatalf("unable to decode the resources expvar: %v", err)
+	}
+}
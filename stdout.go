@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// a network group with this output writes its events to stdout, rather than
+// sending them to servers.
+const outputStdout = "stdout"
+
+// guards stdout, which every stdout group writes to.
+var stdoutLock sync.Mutex
+
+// the event as a flat JSON object: the same keys and values that are sent to
+// a server.
+func (e *FileEvent) jsonObject() map[string]string {
+	v := make(map[string]string, len(e.Fields)+4)
+	for k, val := range e.Fields {
+		v[k] = val
+	}
+	v["file"] = e.Source
+	v["host"] = hostname
+	v["offset"] = strconv.FormatInt(e.Offset, 10)
+	v[e.messageKey()] = e.Text
+	return v
+}
+
+// writes pages of events to stdout, one JSON object per line, for another
+// process to ship.  Each page is flushed before it's passed on to the
+// registrar, so positions are only recorded once their events have been
+// written.  Lumberjack's own logging goes to stderr, or to -log-file, so it's
+// never mixed in with the events.
+func publishStdout(id int, input chan eventPage, registrar chan eventPage) {
+	healthConnected(id, true)
+	for page := range input {
+		for {
+			stdoutLock.Lock()
+			err := writeJSONPage(os.Stdout, page)
+			stdoutLock.Unlock()
+			if err == nil {
+				break
+			}
+			log.Printf("ERROR unable to write %d events to stdout, retrying: %v", len(page), err)
+			time.Sleep(time.Second)
+		}
+		registrar <- page
+	}
+}
+
+func writeJSONPage(out io.Writer, page eventPage) error {
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, e := range page {
+		if err := enc.Encode(e.jsonObject()); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONPage(t *testing.T) {
+	page := eventPage{
+		{Source: "/var/log/a.log", Offset: 0, Text: "one", Fields: map[string]string{"type": "a"}},
+		{Source: "/var/log/a.log", Offset: 4, Text: "two", Fields: map[string]string{}, textKey: "message"},
+	}
+	var buf bytes.Buffer
+	if err := writeJSONPage(&buf, page); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	var first, second map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first["line"] != "one" || first["type"] != "a" || first["file"] != "/var/log/a.log" || first["offset"] != "0" {
+		t.Fatalf("unexpected first event: %v", first)
+	}
+	if second["message"] != "two" || second["offset"] != "4" {
+		t.Fatalf("unexpected second event: %v", second)
+	}
+}
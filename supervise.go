@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	maxRestarts   = 5
+	restartWindow = time.Minute
+)
+
+// supervise runs fn in a new goroutine and restarts it if it panics or
+// returns.  The consumers of the event channels (the spoolers and the
+// registrar) are long-lived; if one of them dies, everything upstream of it
+// blocks forever on a channel send with nothing in the logs to say why.  If fn
+// dies more than maxRestarts times within restartWindow, we give up and shut
+// lumberjack down rather than hang.
+func supervise(name string, fn func()) {
+	go func() {
+		var failures []time.Time
+		for {
+			err := runSupervised(fn)
+			log.Printf("ERROR %s died: %v", name, err)
+
+			now := time.Now()
+			failures = append(failures, now)
+			for len(failures) > 0 && now.Sub(failures[0]) > restartWindow {
+				failures = failures[1:]
+			}
+			if len(failures) > maxRestarts {
+				shutdown(fmt.Sprintf("%s died %d times in %v, giving up: %v",
+					name, len(failures), restartWindow, err))
+			}
+			log.Printf("restarting %s", name)
+		}
+	}()
+}
+
+// runs fn, converting a panic into an error.
+func runSupervised(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	fn()
+	return fmt.Errorf("exited unexpectedly")
+}
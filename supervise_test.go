@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a consumer that panics, or returns, is started again.
+func TestSuperviseRestarts(t *testing.T) {
+	runs := make(chan int, 4)
+	n := 0
+	supervise("test consumer", func() {
+		n++
+		runs <- n
+		switch n {
+		case 1:
+			panic("consumer broke")
+		case 2:
+			return
+		}
+		select {}
+	})
+	for expected := 1; expected <= 3; expected++ {
+		select {
+		case run := <-runs:
+			if run != expected {
+				t.Fatalf("expected run %d, got %d", expected, run)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("consumer wasn't restarted for run %d", expected)
+		}
+	}
+}
+
+// events in a spooler's spool when it panics are picked up again once it's
+// restarted, rather than being lost after they've been counted as sent.
+func TestSpoolPanicReplays(t *testing.T) {
+	input := make(chan *FileEvent)
+	go func() {
+		for _, text := range []string{"one", "two", "three"} {
+			input <- &FileEvent{Text: text}
+		}
+	}()
+	// sending a page on a closed channel panics.
+	closed := make(chan eventPage)
+	close(closed)
+	if err := runSupervised(func() { Spool(input, nil, closed, 2, time.Hour, "", 0) }); !strings.HasPrefix(err.Error(), "panic:") {
+		t.Fatalf("expected the spooler to panic, got %v", err)
+	}
+
+	output := make(chan eventPage, 2)
+	go Spool(input, nil, output, 2, time.Hour, "", 0)
+	var texts []string
+	for len(texts) < 3 {
+		select {
+		case page := <-output:
+			for _, e := range page {
+				texts = append(texts, e.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("events were lost when the spooler panicked, got %v", texts)
+		}
+		if len(texts) == 2 {
+			// the third fills the next page with whatever comes next.
+			input <- &FileEvent{Text: "four"}
+		}
+	}
+	sort.Strings(texts)
+	if strings.Join(texts, ",") != "four,one,three,two" {
+		t.Fatalf("expected every event once, got %v", texts)
+	}
+}
+
+func TestRunSupervisedPanic(t *testing.T) {
+	err := runSupervised(func() { panic("boom") })
+	if err == nil || err.Error()[:12] != "panic: boom\n" {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the largest syslog message accepted over TCP.  Octet counts over this are
+// taken to mean the stream is garbled.
+const maxSyslogMessage = 64 * 1024
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// a syslog message, in either RFC 3164 or RFC 5424 format.
+type syslogMessage struct {
+	facility string
+	severity string
+	hostname string
+	app      string
+	msg      string
+}
+
+// splits a syslog_listen address, e.g. udp://:514, into its network and
+// address.
+func syslogAddr(listen string) (network, addr string, err error) {
+	parts := strings.SplitN(listen, "://", 2)
+	if len(parts) != 2 || (parts[0] != "tcp" && parts[0] != "udp") {
+		return "", "", fmt.Errorf("syslog_listen %q isn't tcp://host:port or udp://host:port", listen)
+	}
+	return parts[0], parts[1], nil
+}
+
+// receives syslog messages on the prospector's syslog_listen address, and
+// ships each as an event, with the message as its text and facility,
+// severity, hostname and app fields.  Messages that can't be parsed are
+// shipped as they are, without the fields.  Over TCP, messages can be framed
+// either by newlines or by octet counts (RFC 6587).  As with sockets,
+// messages not yet shipped when Lumberjack stops are lost.
+func harvestSyslog(conf *FileConfig, out chan *FileEvent) error {
+	network, addr, err := syslogAddr(conf.SyslogListen)
+	if err != nil {
+		return err
+	}
+	stopped := make(chan error, 1)
+	switch network {
+	case "udp":
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %v", conf.SyslogListen, err)
+		}
+		go func() {
+			stopped <- harvestSyslogPackets(conf, c, out)
+		}()
+	case "tcp":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %v", conf.SyslogListen, err)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Temporary() {
+						log.Printf("unable to accept syslog connection, retrying: %v", err)
+						time.Sleep(100 * time.Millisecond)
+						continue
+					}
+					stopped <- fmt.Errorf("unable to accept syslog connection: %v", err)
+					return
+				}
+				go func() {
+					defer conn.Close()
+					if err := harvestSyslogStream(conf, conn, out); err != nil {
+						log.Printf("syslog connection from %s dropped: %v", conn.RemoteAddr(), err)
+					}
+				}()
+			}
+		}()
+	}
+	log.Printf("listening for syslog on %s", conf.SyslogListen)
+	return listenerAlive("syslog:"+conf.SyslogListen, stopped)
+}
+
+func harvestSyslogPackets(conf *FileConfig, c net.PacketConn, out chan *FileEvent) error {
+	h := newReaderHarvester(conf.SyslogListen, nil, conf, out)
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("unable to read syslog packet: %v", err)
+		}
+		h.shipSyslog(strings.TrimRight(string(buf[:n]), "\r\n"))
+	}
+}
+
+func harvestSyslogStream(conf *FileConfig, r io.Reader, out chan *FileEvent) error {
+	h := newReaderHarvester(conf.SyslogListen, nil, conf, out)
+	br := bufio.NewReader(r)
+	for {
+		msg, err := readSyslogFrame(br)
+		if msg != "" {
+			h.shipSyslog(msg)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reads one message from a TCP syslog stream.  A message starting with a
+// digit is octet counted: its length, a space, then that many bytes.
+// Otherwise it runs to the next newline.
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if b[0] < '0' || b[0] > '9' {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	count, err := r.ReadString(' ')
+	if err != nil {
+		return "", fmt.Errorf("unable to read octet count: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+	if err != nil || n > maxSyslogMessage {
+		return "", fmt.Errorf("bad octet count %q", count)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", fmt.Errorf("unable to read %d byte message: %v", n, err)
+	}
+	return strings.TrimRight(string(msg), "\r\n"), nil
+}
+
+func (h *Harvester) shipSyslog(raw string) {
+	m, ok := parseSyslog(raw)
+	if !ok {
+		h.ship(raw, 0, nil)
+		return
+	}
+	fields := map[string]string{"facility": m.facility, "severity": m.severity}
+	if m.hostname != "" {
+		fields["hostname"] = m.hostname
+	}
+	if m.app != "" {
+		fields["app"] = m.app
+	}
+	h.ship(m.msg, 0, fields)
+}
+
+// parses a syslog message in RFC 5424 format, or failing that, RFC 3164.
+func parseSyslog(s string) (syslogMessage, bool) {
+	var m syslogMessage
+	if !strings.HasPrefix(s, "<") {
+		return m, false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return m, false
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri/8 >= len(syslogFacilities) {
+		return m, false
+	}
+	m.facility, m.severity = syslogFacilities[pri/8], syslogSeverities[pri%8]
+	s = s[end+1:]
+
+	if strings.HasPrefix(s, "1 ") {
+		return m, parseSyslog5424(&m, s[2:])
+	}
+	parseSyslog3164(&m, s)
+	return m, true
+}
+
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseSyslog5424(m *syslogMessage, s string) bool {
+	header := strings.SplitN(s, " ", 6)
+	if len(header) != 6 {
+		return false
+	}
+	nil5424 := func(v string) string {
+		if v == "-" {
+			return ""
+		}
+		return v
+	}
+	m.hostname, m.app = nil5424(header[1]), nil5424(header[2])
+
+	rest := header[5]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		// skip the structured data elements: [id key="value" ...]...
+		for strings.HasPrefix(rest, "[") {
+			i, quoted := 1, false
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' {
+					i++
+				} else if rest[i] == '"' {
+					quoted = !quoted
+				} else if rest[i] == ']' && !quoted {
+					break
+				}
+			}
+			if i >= len(rest) {
+				return false
+			}
+			rest = rest[i+1:]
+		}
+	}
+	m.msg = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return true
+}
+
+// [TIMESTAMP HOSTNAME ]TAG[PID]: MSG, though much of what's sent varies from
+// that.  Whatever can't be made out is left in the message.
+func parseSyslog3164(m *syslogMessage, s string) {
+	if len(s) > len(time.Stamp) && s[len(time.Stamp)] == ' ' {
+		if _, err := time.Parse(time.Stamp, s[:len(time.Stamp)]); err == nil {
+			s = s[len(time.Stamp)+1:]
+			if i := strings.IndexByte(s, ' '); i > 0 && !strings.HasSuffix(s[:i], ":") {
+				m.hostname, s = s[:i], s[i+1:]
+			}
+		}
+	}
+	if i := strings.Index(s, ": "); i > 0 && !strings.Contains(s[:i], " ") {
+		tag := s[:i]
+		if j := strings.IndexByte(tag, '['); j > 0 {
+			tag = tag[:j]
+		}
+		m.app, s = tag, s[i+2:]
+	}
+	m.msg = s
+}
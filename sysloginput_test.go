@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSyslog(t *testing.T) {
+	for _, c := range []struct {
+		raw      string
+		expected syslogMessage
+	}{
+		{`<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8`,
+			syslogMessage{"auth", "crit", "mymachine", "su", "'su root' failed for lonvick on /dev/pts/8"}},
+		{`<13>myapp: hello`,
+			syslogMessage{"user", "notice", "", "myapp", "hello"}},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventID="1011"] An application event`,
+			syslogMessage{"local4", "notice", "mymachine.example.com", "evntslog", "An application event"}},
+		{`<165>1 2003-10-11T22:14:15.003Z - - - - [a x="]"][b] quoted`,
+			syslogMessage{"local4", "notice", "", "", "quoted"}},
+		{`<86>1 2003-10-11T22:14:15.003Z host sshd 42 - -`,
+			syslogMessage{"authpriv", "info", "host", "sshd", ""}},
+	} {
+		m, ok := parseSyslog(c.raw)
+		if !ok || m != c.expected {
+			t.Errorf("%s: expected %+v, got %+v, %v", c.raw, c.expected, m, ok)
+		}
+	}
+	if _, ok := parseSyslog("no priority"); ok {
+		t.Errorf("expected a message without a priority not to parse")
+	}
+}
+
+func TestHarvestSyslogStream(t *testing.T) {
+	out := make(chan *FileEvent, 16)
+	conf := &FileConfig{SyslogListen: "tcp://:5514"}
+	// newline framed, then octet counted with a newline inside.
+	input := "<13>app: one\n" + "14 <13>app: two\nx" + "<13>app: three"
+	if err := harvestSyslogStream(conf, strings.NewReader(input), out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var texts []string
+	for e := range out {
+		texts = append(texts, e.Text)
+		if e.Fields["app"] != "app" || e.Source != "tcp://:5514" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	if strings.Join(texts, ",") != "one,two\nx,three" {
+		t.Fatalf("expected one, two\\nx and three, got %q", texts)
+	}
+}
+
+// the parsed fields are there for the processors to work on.
+func TestHarvestSyslogProcessors(t *testing.T) {
+	out := make(chan *FileEvent, 1)
+	conf := &FileConfig{SyslogListen: "tcp://:5514", Processors: []processor{
+		{Rename: map[string]string{"severity": "level"}},
+		{DropFields: []string{"hostname"}},
+	}}
+	input := "<34>Oct 11 22:14:15 mymachine su: failed\n"
+	if err := harvestSyslogStream(conf, strings.NewReader(input), out); err != nil {
+		t.Fatal(err)
+	}
+	e := <-out
+	if e.Fields["level"] != "crit" || e.Fields["severity"] != "" || e.Fields["hostname"] != "" {
+		t.Fatalf("expected the processors to rename severity and drop hostname, got %v", e.Fields)
+	}
+}
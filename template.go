@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a fieldTemplate is a prospector field value that refers to per-event
+// values, e.g. "{{source}}:{{offset}}".  The supported variables are:
+//
+//   - {{source}}: the path of the file the event was read from
+//   - {{offset}}: the byte offset of the event in that file
+//   - {{host}}: the hostname lumberjack is running on
+//
+// Templates are compiled once, when a harvester is created, since they're
+// rendered for every event.
+type fieldTemplate []templatePart
+
+type templatePart struct {
+	literal string
+	value   func(e *FileEvent) string // nil for literal parts
+}
+
+var templateVars = map[string]func(e *FileEvent) string{
+	"source": func(e *FileEvent) string { return e.Source },
+	"offset": func(e *FileEvent) string { return strconv.FormatInt(e.Offset, 10) },
+	"host":   func(e *FileEvent) string { return hostname },
+}
+
+func isFieldTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+func compileFieldTemplate(s string) (fieldTemplate, error) {
+	var t fieldTemplate
+	for s != "" {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			t = append(t, templatePart{literal: s})
+			break
+		}
+		if start > 0 {
+			t = append(t, templatePart{literal: s[:start]})
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated template variable in %q", s)
+		}
+		name := strings.TrimSpace(s[start+2 : start+end])
+		value, ok := templateVars[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown template variable %q", name)
+		}
+		t = append(t, templatePart{value: value})
+		s = s[start+end+2:]
+	}
+	return t, nil
+}
+
+func (t fieldTemplate) render(e *FileEvent) string {
+	var buf bytes.Buffer
+	for _, p := range t {
+		if p.value != nil {
+			buf.WriteString(p.value(e))
+		} else {
+			buf.WriteString(p.literal)
+		}
+	}
+	return buf.String()
+}
+
+// compiles the templated values in fields.  Values that fail to compile are
+// logged and shipped as they are.
+func compileFieldTemplates(fields map[string]string) (map[string]fieldTemplate, []error) {
+	var templates map[string]fieldTemplate
+	var errs []error
+	for k, v := range fields {
+		if !isFieldTemplate(v) {
+			continue
+		}
+		t, err := compileFieldTemplate(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %v", k, err))
+			continue
+		}
+		if templates == nil {
+			templates = make(map[string]fieldTemplate)
+		}
+		templates[k] = t
+	}
+	return templates, errs
+}
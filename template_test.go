@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFieldTemplate(t *testing.T) {
+	tmpl, err := compileFieldTemplate("id-{{source}}:{{ offset }}")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	v := tmpl.render(&FileEvent{Source: "/var/log/app.log", Offset: 42})
+	if v != "id-/var/log/app.log:42" {
+		t.Fatalf("unexpected render: %q", v)
+	}
+
+	if _, err := compileFieldTemplate("{{nope}}"); err == nil {
+		t.Fatalf("expected an error for an unknown variable")
+	}
+	if _, err := compileFieldTemplate("{{source"); err == nil {
+		t.Fatalf("expected an error for an unterminated variable")
+	}
+}
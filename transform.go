@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// the signature of the Transform function a transform plugin must export.
+// It's given the text and fields of each event, and returns the text and
+// fields to ship instead, and false if the event should be dropped.
+type transformFunc func(text string, fields map[string]string) (string, map[string]string, bool)
+
+// a Go plugin, loaded from the path given as a prospector's
+// "transform_plugin", for custom processing of events.  See loadTransform for
+// where plugins are supported.
+type transformPlugin struct {
+	path      string
+	transform transformFunc
+}
+
+func (p *transformPlugin) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &p.path); err != nil {
+		return fmt.Errorf("cannot unmarshal transform_plugin: %v", err)
+	}
+	f, err := loadTransform(p.path)
+	if err != nil {
+		return fmt.Errorf("unable to load transform_plugin %s: %v", p.path, err)
+	}
+	p.transform = f
+	return nil
+}
+
+// passes e through the plugin's Transform, and returns whether to ship it.
+// If Transform panics, the event is shipped as it was.
+func (p *transformPlugin) apply(e *FileEvent) (keep bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR transform plugin %s panicked, shipping the event as is: %v", p.path, r)
+			keep = true
+		}
+	}()
+	text, fields, keep := p.transform(e.Text, copyFields(e.Fields))
+	if !keep {
+		return false
+	}
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+	e.Text, e.Fields = text, fields
+	return true
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	c := make(map[string]string, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,11 @@
+// +build !linux,!darwin !cgo
+
+package main
+
+import (
+	"fmt"
+)
+
+func loadTransform(path string) (transformFunc, error) {
+	return nil, fmt.Errorf("plugins aren't supported in this build")
+}
@@ -0,0 +1,26 @@
+// +build linux,cgo darwin,cgo
+
+package main
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// opens the Go plugin at path and looks up its Transform function.  Plugins
+// need cgo, and only work on Linux and macOS.
+func loadTransform(path string) (transformFunc, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Transform")
+	if err != nil {
+		return nil, err
+	}
+	f, ok := sym.(func(string, map[string]string) (string, map[string]string, bool))
+	if !ok {
+		return nil, fmt.Errorf("Transform is a %T, not a func(string, map[string]string) (string, map[string]string, bool)", sym)
+	}
+	return f, nil
+}
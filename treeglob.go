@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// a path in files can have "**" as one of its directories, which matches
+// any number of directories, including none, e.g. /var/log/**/*.log matches
+// /var/log/a.log and /var/log/app/2014/b.log.  Such paths are found by
+// walking the directory tree under the part of the path before the **,
+// which on a big tree can take a while, so:
+//
+//   - max_depth limits how many directories deep the ** goes, and
+//   - scan_budget_ms limits how long each scan walks for.  A walk that runs
+//     out of time carries on where it left off on the next scan, so files
+//     deep in a huge tree are found, and checked for rotation, a few scans
+//     later rather than holding up the prospector.
+//
+// Symlinks to directories aren't followed, so a link back up the tree can't
+// make a walk go round in circles.
+const treeGlobDirs = "**"
+
+// splits a path with a ** directory into the glob before it and the glob
+// after.  ok is false for ordinary paths.
+func splitTreeGlob(path string) (prefix, suffix string, ok bool) {
+	sep := string(filepath.Separator)
+	i := strings.Index(path, sep+treeGlobDirs+sep)
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i], path[i+len(sep+treeGlobDirs+sep):], true
+}
+
+// checks that a path's ** is a whole directory, and that there's only one.
+func checkTreeGlob(path string) error {
+	if !strings.Contains(path, treeGlobDirs) {
+		return nil
+	}
+	prefix, suffix, ok := splitTreeGlob(path)
+	if !ok || strings.Contains(prefix, treeGlobDirs) || strings.Contains(suffix, treeGlobDirs) {
+		return fmt.Errorf("path %s: ** must be a whole directory, used once", path)
+	}
+	return nil
+}
+
+// reports whether the path rel, relative to a directory matched by the glob
+// before a **, matches the glob after it, with no more than maxDepth
+// directories in between, or any number if maxDepth is 0.
+func matchBelow(suffix, rel string, maxDepth int) bool {
+	sep := string(filepath.Separator)
+	want := strings.Count(suffix, sep) + 1
+	segs := strings.Split(rel, sep)
+	depth := len(segs) - want
+	if depth < 0 || (maxDepth > 0 && depth > maxDepth) {
+		return false
+	}
+	ok, _ := filepath.Match(suffix, strings.Join(segs[depth:], sep))
+	return ok
+}
+
+// like filepath.Match, but understands **.
+func matchPath(pattern, path string, maxDepth int) bool {
+	prefix, suffix, ok := splitTreeGlob(pattern)
+	if !ok {
+		match, err := filepath.Match(pattern, path)
+		if err != nil {
+			log.Printf("error matching file path: %s", err.Error())
+		}
+		return match
+	}
+	sep := string(filepath.Separator)
+	n := strings.Count(prefix, sep) + 1
+	segs := strings.Split(path, sep)
+	if len(segs) <= n {
+		return false
+	}
+	if match, _ := filepath.Match(prefix, strings.Join(segs[:n], sep)); !match {
+		return false
+	}
+	return matchBelow(suffix, strings.Join(segs[n:], sep), maxDepth)
+}
+
+// an incremental walk of the trees under a path's **.
+type treeWalk struct {
+	prefix, suffix string
+	pending        []walkDir // directories still to be read this time round
+	found          int       // files matched so far this time round
+	lastFound      int       // files matched the last time round
+}
+
+type walkDir struct {
+	root  string // the directory matched by prefix that this is under
+	path  string
+	depth int // directories below root
+}
+
+// returns the files matched by path, which may have a ** in it.  For a
+// path with a **, only the files found within the scan budget are returned,
+// along with the number of files the path matches as far as is known.
+func (f *FileConfig) glob(path string) ([]string, int, error) {
+	prefix, suffix, ok := splitTreeGlob(path)
+	if !ok {
+		matches, err := filepath.Glob(path)
+		return matches, len(matches), err
+	}
+	if f.walks == nil {
+		f.walks = make(map[string]*treeWalk)
+	}
+	w, ok := f.walks[path]
+	if !ok {
+		w = &treeWalk{prefix: prefix, suffix: suffix}
+		f.walks[path] = w
+	}
+	var deadline time.Time
+	if f.ScanBudgetMs > 0 {
+		deadline = time.Now().Add(time.Duration(f.ScanBudgetMs) * time.Millisecond)
+	}
+	matches, err := w.next(f.MaxDepth, deadline)
+	known := w.found
+	if w.lastFound > known {
+		known = w.lastFound
+	}
+	return matches, known, err
+}
+
+// walks on from where the last call stopped until the walk is done, or the
+// deadline, if set, has passed, and returns the files found on the way.  A
+// new walk is started if the last one finished.
+func (w *treeWalk) next(maxDepth int, deadline time.Time) ([]string, error) {
+	if len(w.pending) == 0 {
+		roots, err := filepath.Glob(w.prefix)
+		if err != nil {
+			return nil, err
+		}
+		w.lastFound, w.found = w.found, 0
+		for i := len(roots) - 1; i >= 0; i-- {
+			w.pending = append(w.pending, walkDir{roots[i], roots[i], 0})
+		}
+	}
+
+	// the directories in the glob after the ** are below the ones the **
+	// matches.
+	limit := 0
+	if maxDepth > 0 {
+		limit = maxDepth + strings.Count(w.suffix, string(filepath.Separator))
+	}
+	var matches []string
+	for len(w.pending) > 0 {
+		dir := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		infos, err := ioutil.ReadDir(dir.path)
+		if err != nil {
+			// gone, or not a directory.
+			continue
+		}
+		var subdirs []walkDir
+		for _, info := range infos {
+			path := filepath.Join(dir.path, info.Name())
+			if info.IsDir() {
+				if limit == 0 || dir.depth < limit {
+					subdirs = append(subdirs, walkDir{dir.root, path, dir.depth + 1})
+				}
+				continue
+			}
+			rel, err := filepath.Rel(dir.root, path)
+			if err == nil && matchBelow(w.suffix, rel, maxDepth) {
+				matches = append(matches, path)
+			}
+		}
+		// pushed in reverse, so the walk goes through them in order.
+		for i := len(subdirs) - 1; i >= 0; i-- {
+			w.pending = append(w.pending, subdirs[i])
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+	}
+	w.found += len(matches)
+	return matches, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatchPath(t *testing.T) {
+	for _, c := range []struct {
+		pattern, path string
+		maxDepth      int
+		match         bool
+	}{
+		{"/var/log/*.log", "/var/log/a.log", 0, true},
+		{"/var/log/**/*.log", "/var/log/a.log", 0, true},
+		{"/var/log/**/*.log", "/var/log/app/2014/b.log", 0, true},
+		{"/var/log/**/*.log", "/var/log/app/2014/b.log", 1, false},
+		{"/var/log/**/*.log", "/var/log/app/b.txt", 0, false},
+		{"/var/*/**/logs/*.log", "/var/app/x/logs/c.log", 0, true},
+		{"/var/*/**/logs/*.log", "/var/app/x/c.log", 0, false},
+		{"/var/log/**/*.log", "/srv/log/a.log", 0, false},
+	} {
+		if match := matchPath(c.pattern, c.path, c.maxDepth); match != c.match {
+			t.Errorf("%s against %s, max depth %d: expected %v", c.pattern, c.path, c.maxDepth, c.match)
+		}
+	}
+}
+
+func TestTreeGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treeglob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var all []string
+	for _, name := range []string{"a.log", "x/b.log", "x/y/c.log", "x/y/z/d.log", "x/e.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Ext(name) == ".log" {
+			all = append(all, path)
+		}
+	}
+	pattern := filepath.Join(dir, "**", "*.log")
+
+	conf := &FileConfig{MaxDepth: 2}
+	matches, n, err := conf.glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matches, all[:3]) || n != 3 {
+		t.Fatalf("expected %v with a max depth of 2, got %v (%d)", all[:3], matches, n)
+	}
+
+	// with the deadline already passed, each scan reads one directory, and
+	// carries on from there the next time.
+	w := &treeWalk{prefix: dir, suffix: "*.log"}
+	past := time.Now().Add(-time.Second)
+	for i, expected := range all {
+		matches, err := w.next(0, past)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 || matches[0] != expected {
+			t.Fatalf("scan %d: expected %s, got %v", i, expected, matches)
+		}
+	}
+	if matches, _ := w.next(0, past); len(matches) != 1 || matches[0] != all[0] || w.lastFound != len(all) {
+		t.Fatalf("expected the walk to start again having found %d files, got %v and %d", len(all), matches, w.lastFound)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=0.4.3 -X main.buildCommit=$(git rev-parse --short HEAD)"
+var (
+	version     = "dev"
+	buildCommit = "unknown"
+)
+
+// returns fields describing this lumberjack, to be added to every event,
+// named with the given prefix: prefix_name, prefix_version, prefix_commit
+// and prefix_host.
+func agentFields(prefix string) map[string]string {
+	return map[string]string{
+		prefix + "_name":    "lumberjack",
+		prefix + "_version": version,
+		prefix + "_commit":  buildCommit,
+		prefix + "_host":    hostname,
+	}
+}
@@ -10,8 +10,9 @@ import (
 
 var (
 	watcher   *inotify.Watcher
-	watchDirs = make(map[string]bool)
-	watchLock sync.Mutex
+	watchDirs = make(map[string]int) // harvesters in each watched directory
+	watchLock sync.Mutex             // guards watchDirs and pollOnly
+	pollOnly  bool                   // see polling
 
 	lr_suffixes = []*regexp.Regexp{
 		regexp.MustCompile("\\.\\d+$"), // numeric suffix (default sufix)
@@ -35,7 +36,7 @@ func copyTruncate(fullPath string) {
 		return
 	}
 	h := registry.byPath(path)
-	if h != nil {
+	if h != nil && h.rotation() != rotateCreate {
 		h.nextPath = fullPath
 	}
 }
@@ -75,22 +76,70 @@ func reportFSEvents() {
 	}
 }
 
+// watches the directory of a harvester's file.  Each directory is watched
+// once, however many harvesters there are in it, since the kernel limits how
+// many watches there can be.  Every call must be matched by a call to
+// unwatchDir once the harvester is done.
 func watchDir(path string) {
-	if !watchDirs[path] {
+	if polling() {
+		return
+	}
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	if watchDirs[path] == 0 {
 		flags := inotify.IN_CREATE | inotify.IN_DELETE | inotify.IN_MOVE
 		if err := watcher.AddWatch(path, flags); err != nil {
-			log.Printf("unable to watch directory: %s", err.Error())
-		} else {
-			watchDirs[path] = true
+			log.Printf("unable to watch directory %s, falling back to polling: %s", path, err.Error())
+			pollOnly = true
+			return
 		}
 	}
+	watchDirs[path]++
+}
+
+// stops watching a harvester's directory once the last harvester in it is
+// done.
+func unwatchDir(path string) {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	n, ok := watchDirs[path]
+	if !ok {
+		return
+	}
+	if n > 1 {
+		watchDirs[path] = n - 1
+		return
+	}
+	delete(watchDirs, path)
+	if err := watcher.RemoveWatch(path); err != nil {
+		log.Printf("unable to stop watching directory %s: %s", path, err.Error())
+	}
 }
 
-func init() {
-	var err error
-	watcher, err = inotify.NewWatcher()
+// whether we're relying on polling alone to notice files being rotated, as
+// with -watcher=poll, or because directories can't be watched.  Watching
+// notices renames as they happen, but doesn't work on some network
+// filesystems and in some containers.  Polling notices that a path refers to
+// a new file when it's next checked, and is slower, but works anywhere.
+func polling() bool {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	return pollOnly || watcher == nil
+}
+
+// starts watching directories for renames, unless -watcher=poll.
+func startWatcher() {
+	if options.Watcher == "poll" {
+		log.Println("polling for rotated files")
+		return
+	}
+	w, err := inotify.NewWatcher()
 	if err != nil {
-		log.Printf("unable to start watcher: %s", err.Error())
+		log.Printf("unable to start watcher, falling back to polling: %s", err.Error())
 		return
 	}
+	watchLock.Lock()
+	watcher = w
+	watchLock.Unlock()
+	go reportFSEvents()
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"code.google.com/p/go.exp/inotify"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// a directory is watched once for all the harvesters in it, and stops being
+// watched when the last of them is done.
+func TestWatchDirCounted(t *testing.T) {
+	w, err := inotify.NewWatcher()
+	if err != nil {
+		t.Skipf("unable to start watcher: %v", err)
+	}
+	defer w.Close()
+	defer func(w *inotify.Watcher, p bool) { watcher, pollOnly = w, p }(watcher, pollOnly)
+	watcher, pollOnly = w, false
+
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	watchDir(dir)
+	watchDir(dir)
+	if n := watchDirs[dir]; n != 2 {
+		t.Fatalf("expected 2 harvesters counted, got %d", n)
+	}
+	unwatchDir(dir)
+	if n := watchDirs[dir]; n != 1 {
+		t.Fatalf("expected 1 harvester counted, got %d", n)
+	}
+	unwatchDir(dir)
+	if _, ok := watchDirs[dir]; ok {
+		t.Fatalf("expected %s not to be watched", dir)
+	}
+}
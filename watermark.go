@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+// whether text has a timestamp older than the configured timestamp_max_age,
+// in which case it's dropped rather than shipped.  This is for re-harvesting
+// a big file when only its recent events matter.  Dropped events are still
+// read past, but as nothing is acknowledged for them, a run of them is read
+// again after a restart, and dropped again.
+//
+// The timestamp is looked for in the whole event, so for joined events it's
+// normally the first line's.  Events without a timestamp that can be parsed
+// are shipped.
+func (h *Harvester) tooOld(text string) bool {
+	if h.conf == nil || h.conf.TimestampPattern == nil {
+		return false
+	}
+	t, ok := parseTimestamp(text, h.conf.TimestampPattern, h.conf.TimestampLayout, h.clock.Now())
+	if !ok {
+		return false
+	}
+	maxAge := time.Duration(h.conf.TimestampMaxAge) * time.Second
+	return h.clock.Now().Sub(t) > maxAge
+}
+
+// finds and parses the timestamp in text.  Timestamps without a time zone
+// are taken to be local time, and those without a year, as in syslog, are
+// taken to be from the year up to now.
+func parseTimestamp(text string, p *pattern, layout string, now time.Time) (time.Time, bool) {
+	m := p.FindStringSubmatch(text)
+	if m == nil {
+		return time.Time{}, false
+	}
+	s := m[0]
+	if len(m) > 1 {
+		s = m[1]
+	}
+	t, err := time.ParseInLocation(layout, s, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if t.Year() == 0 {
+		t = t.AddDate(now.Year(), 0, 0)
+		if t.After(now.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// how often a whole file harvester checks its file for changes
+const wholeFilePoll = time.Second
+
+// readWhole harvests a status file: a file that's overwritten in place rather
+// than appended to.  Instead of tailing it, the whole file is read and shipped
+// as a single event every time it changes.  Offsets don't mean anything for
+// such files, so nothing is recorded in the progress file, and truncation and
+// rotation handling don't apply.
+//
+// A file is only read once its size and modification time have stayed the
+// same for the debounce period, so a burst of writes results in one event.
+func (h *Harvester) readWhole() {
+	var err error
+	if h.fi, err = statFile(h.Path); err != nil {
+		log.Printf("unable to stat whole file %s: %v", h.Path, err)
+		return
+	}
+	if err := registry.register(h); err != nil {
+		log.Printf("readWhole unable to register: %v", err)
+		return
+	}
+	defer registry.unregister(h)
+
+	debounce := time.Duration(h.conf.WholeFileDebounceMs) * time.Millisecond
+	var shipped os.FileInfo // as of the last time we shipped the file
+	var changed time.Time   // when we first noticed the current change
+	for {
+		waitWhilePaused()
+		info, err := statFile(h.Path)
+		if err != nil {
+			log.Printf("whole file harvester for %s stopping: %v", h.Path, err)
+			return
+		}
+		if shipped != nil && info.Size() == shipped.Size() && info.ModTime().Equal(shipped.ModTime()) {
+			changed = time.Time{}
+			time.Sleep(wholeFilePoll)
+			continue
+		}
+		if changed.IsZero() || info.ModTime().After(changed) {
+			changed = time.Now()
+		}
+		if time.Since(changed) < debounce {
+			time.Sleep(debounce - time.Since(changed))
+			continue
+		}
+
+		content, err := ioutil.ReadFile(h.Path)
+		if err != nil {
+			log.Printf("whole file harvester for %s stopping: %v", h.Path, err)
+			return
+		}
+		h.fi = info
+		e := h.event(string(content), 0, nil)
+		e.fileinfo = nil
+		if h.conf.WholeFileMtime {
+			// there's no time in a status file to go by, but when it was
+			// last written is a good stand in.
+			e.Fields["@timestamp"] = h.fi.ModTime().UTC().Format(timestampFormat)
+		}
+		h.send(e)
+		shipped, changed = info, time.Time{}
+	}
+}
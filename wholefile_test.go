@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWholeFileMtime(t *testing.T) {
+	testRegistry()
+	// the harvester stops once the file is removed.
+	defer endHarvesters()
+	f, err := ioutil.TempFile("", "wholefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("status: ok\n")
+	f.Close()
+	defer os.Remove(f.Name())
+	mtime := time.Date(2014, 3, 1, 12, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(f.Name(), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan *FileEvent, 1)
+	h := newHarvester(f.Name(), &FileConfig{WholeFile: true, WholeFileMtime: true}, out)
+	testHarvesting.Add(1)
+	go func() {
+		defer testHarvesting.Done()
+		h.readWhole()
+	}()
+	select {
+	case e := <-out:
+		if ts := e.Fields["@timestamp"]; ts != "2014-03-01T12:30:00.000Z" {
+			t.Fatalf("expected the file's modification time, got %q", ts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the whole file event")
+	}
+}
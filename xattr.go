@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// with -progress-store=xattr, the position reached in each file is stored in
+// this extended attribute of the file itself rather than in the progress
+// file, so it can't be lost separately from the file.  Files whose
+// filesystem doesn't support extended attributes, or which we can't write
+// attributes to, fall back to the progress file.
+const offsetXattr = "user.lsf.offset"
+
+func useXattrs() bool {
+	return options.ProgressStore == "xattr"
+}
+
+// files that positions couldn't be stored in, so we only complain once.
+var xattrFailed = make(map[string]bool)
+
+// stores the positions in p in each file's offset attribute, and returns the
+// ones that couldn't be stored, which need to go to the progress file.
+func (p progress) writeXattrs() progress {
+	rest := make(progress)
+	for source, state := range p {
+		if err := setOffsetXattr(source, state.Offset); err != nil {
+			if !xattrFailed[source] {
+				log.Printf("unable to store position of %s in an extended attribute, using the progress file instead: %v", source, err)
+				xattrFailed[source] = true
+			}
+			rest[source] = state
+			continue
+		}
+		delete(xattrFailed, source)
+	}
+	return rest
+}
+
+// returns the position stored in the offset attribute of file, if it has
+// one that makes sense for it.
+func xattrOffset(file *os.File) (int64, bool) {
+	offset, err := getOffsetXattr(file.Name())
+	if err != nil {
+		return 0, false
+	}
+	info, err := file.Stat()
+	if err != nil || offset < 0 || offset > info.Size() {
+		// the file has been truncated since.
+		return 0, false
+	}
+	return offset, true
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// reads the offset stored in the offset extended attribute of the file at
+// path.
+func getOffsetXattr(path string) (int64, error) {
+	buf := make([]byte, 32)
+	n, err := syscall.Getxattr(path, offsetXattr, buf)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(buf[:n]), 10, 64)
+}
+
+// stores offset in the offset extended attribute of the file at path.
+func setOffsetXattr(path string, offset int64) error {
+	return syscall.Setxattr(path, offsetXattr, []byte(strconv.FormatInt(offset, 10)), 0)
+}
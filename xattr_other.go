@@ -0,0 +1,17 @@
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+var errNoXattr = errors.New("extended attributes are not supported on this platform")
+
+func getOffsetXattr(path string) (int64, error) {
+	return 0, errNoXattr
+}
+
+func setOffsetXattr(path string, offset int64) error {
+	return errNoXattr
+}